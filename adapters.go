@@ -0,0 +1,53 @@
+package trustedproxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestFunc returns a function storing the ForwardedRequest in the context, compatible with the go-kit
+// httptransport.RequestFunc for httptransport.ServerBefore, the endpoints then use FromContext or
+// ResultFromContext. A request which fails to resolve is passed to the ErrorHandler with a ResponseWriter
+// discarding the response, go-kit has no way to stop the request there, and the context is returned unchanged.
+func (h *HTTPHandler) RequestFunc() func(ctx context.Context, r *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		res := ctx
+		h.SetTrustedProxyContext(discardResponseWriter{}, r.WithContext(ctx), http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			res = r.Context()
+		}))
+		return res
+	}
+}
+
+// Filter returns a middleware storing the ForwardedRequest in the request context like WithTrustedProxyContext,
+// compatible with the Kratos http.FilterFunc for http.Filter.
+func (h *HTTPHandler) Filter() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.SetTrustedProxyContext(w, r, next)
+		})
+	}
+}
+
+// ResultFromContext returns the Result of the ForwardedRequest in the context, for endpoint level code which
+// only sees the context.
+func ResultFromContext(ctx context.Context) (Result, bool) {
+	fr, ok := FromContext(ctx)
+	if !ok {
+		return Result{}, false
+	}
+	return fr.GetResult(), true
+}
+
+// discardResponseWriter is a http.ResponseWriter discarding everything
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (discardResponseWriter) WriteHeader(int) {}
@@ -0,0 +1,33 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultBaggageKey is the baggage key of the trusted remote address suggested for ForwardOptions.BaggageKey,
+// named after the client.address attribute of the OpenTelemetry semantic conventions.
+const DefaultBaggageKey = "client.address"
+
+// setBaggage sets the member of the W3C baggage header, the members of the same key are removed from every
+// line and the others are kept in order
+// see https://www.w3.org/TR/baggage/
+func setBaggage(h http.Header, key string, value string) {
+	var members []string
+	for _, line := range h.Values("Baggage") {
+		for _, member := range strings.Split(line, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			name, _, _ := strings.Cut(member, "=")
+			if strings.TrimSpace(name) == key {
+				continue
+			}
+			members = append(members, member)
+		}
+	}
+	members = append(members, key+"="+url.PathEscape(value))
+	h.Set("Baggage", strings.Join(members, ","))
+}
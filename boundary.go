@@ -0,0 +1,126 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultResultHeader is the conventional header a gateway passes its Result to a nested gateway in,
+// see ForwardOptions.ResultHeader and HTTPHandler.ResultHeader.
+const DefaultResultHeader = "X-Trusted-Result"
+
+// EncodeResult returns the header form of the result, a query string with the remote, proxy, for, proxies,
+// peer_port, host, proto, port and degraded parameters. The timings are not encoded.
+func EncodeResult(res Result) string {
+	v := url.Values{}
+	if res.RemoteAddr != nil {
+		v.Set("remote", res.RemoteAddr.String())
+	}
+	if res.ProxyIP != nil {
+		v.Set("proxy", res.ProxyIP.String())
+	}
+	if len(res.ForwardedFor) > 0 {
+		v.Set("for", joinIPs(res.ForwardedFor))
+	}
+	if len(res.Proxies) > 0 {
+		v.Set("proxies", joinIPs(res.Proxies))
+	}
+	if res.PeerPort != 0 {
+		v.Set("peer_port", strconv.Itoa(res.PeerPort))
+	}
+	if res.Host != "" {
+		v.Set("host", res.Host)
+	}
+	if res.Proto != "" {
+		v.Set("proto", res.Proto)
+	}
+	if res.Port != 0 {
+		v.Set("port", strconv.Itoa(res.Port))
+	}
+	if res.Degraded {
+		v.Set("degraded", "1")
+	}
+	return v.Encode()
+}
+
+// DecodeResult parses the header form of a result produced by EncodeResult, the remote address is required.
+func DecodeResult(s string) (Result, error) {
+	v, err := url.ParseQuery(strings.TrimSpace(s))
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid result: %w", err)
+	}
+	var res Result
+	if res.RemoteAddr = net.ParseIP(v.Get("remote")); res.RemoteAddr == nil {
+		return Result{}, fmt.Errorf("invalid result remote %q", v.Get("remote"))
+	}
+	if p := v.Get("proxy"); p != "" {
+		if res.ProxyIP = net.ParseIP(p); res.ProxyIP == nil {
+			return Result{}, fmt.Errorf("invalid result proxy %q", p)
+		}
+	}
+	if res.ForwardedFor, err = splitIPs(v.Get("for")); err != nil {
+		return Result{}, err
+	}
+	if res.Proxies, err = splitIPs(v.Get("proxies")); err != nil {
+		return Result{}, err
+	}
+	for name, dst := range map[string]*int{"peer_port": &res.PeerPort, "port": &res.Port} {
+		if p := v.Get(name); p != "" {
+			port, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return Result{}, fmt.Errorf("invalid result %s %q", name, p)
+			}
+			*dst = int(port)
+		}
+	}
+	res.Host = v.Get("host")
+	res.Proto = strings.ToLower(v.Get("proto"))
+	if res.Proto != "" && res.Proto != "http" && res.Proto != "https" {
+		return Result{}, fmt.Errorf("invalid result proto %q", res.Proto)
+	}
+	res.Degraded = v.Get("degraded") == "1"
+	return res, nil
+}
+
+// adoptResult replaces the resolved values with the result of the outer gateway, which is the immediate
+// peer, so the nested gateways agree on the client instead of walking the chain twice
+func (f *forwardedRequest) adoptResult(res Result, peer net.IP) {
+	f.trustedRemoteAddr = res.RemoteAddr
+	f.trustedForwardedFor = res.ForwardedFor
+	f.trustedProxies = append(append([]net.IP{}, res.Proxies...), peer)
+	f.proxyIP = res.ProxyIP
+	if f.proxyIP == nil {
+		// the client connected to the outer gateway directly
+		f.proxyIP = peer
+	}
+	f.trustedHost = res.Host
+	f.trustedProto = res.Proto
+	f.trustedPort = res.Port
+	f.degraded = f.degraded || res.Degraded
+}
+
+func joinIPs(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func splitIPs(s string) ([]net.IP, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var res []net.IP
+	for _, part := range strings.Split(s, ",") {
+		ip := net.ParseIP(strings.TrimSpace(part))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid result ip %q", part)
+		}
+		res = append(res, ip)
+	}
+	return res, nil
+}
@@ -0,0 +1,51 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResultHeaderTrust(t *testing.T) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway, err := NewCIDRWhitelist("10.9.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoofed := EncodeResult(Result{RemoteAddr: parseIPEntry("8.8.8.8"), Host: "admin.internal", Proto: "https"})
+	tests := []struct {
+		name   string
+		trust  IPExtractor
+		peer   string
+		remote string
+		host   string
+	}{
+		{"no result trust", nil, "10.0.0.2:4711", "203.0.113.7", "example.com"},
+		{"peer is not an outer gateway", gateway, "10.0.0.2:4711", "203.0.113.7", "example.com"},
+		{"peer is an outer gateway", gateway, "10.9.0.2:4711", "8.8.8.8", "admin.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTTPHandler{Extractor: cdn, ResultHeader: DefaultResultHeader, ResultTrust: tt.trust}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.peer
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.Header.Set(DefaultResultHeader, spoofed)
+			var got Result
+			var header string
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = ResultFromContext(r.Context())
+				header = r.Header.Get(DefaultResultHeader)
+			}))
+			if got.RemoteAddr.String() != tt.remote || got.Host != tt.host {
+				t.Errorf("resolved %v %q, want %s %q", got.RemoteAddr, got.Host, tt.remote, tt.host)
+			}
+			if tt.remote != "8.8.8.8" && header != "" {
+				t.Errorf("untrusted result header %q is kept", header)
+			}
+		})
+	}
+}
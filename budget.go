@@ -0,0 +1,98 @@
+package trustedproxy
+
+import "sync/atomic"
+
+const (
+	// MetricBudgetUsed is the gauge of the bytes reserved from a MemoryBudget.
+	MetricBudgetUsed = "budget_used_bytes"
+
+	// MetricBudgetRejected is incremented when a reservation does not fit in a MemoryBudget.
+	MetricBudgetRejected = "budget_rejected"
+)
+
+// entryOverhead is the rough cost of a map or slice entry besides its strings
+const entryOverhead = 64
+
+// GaugeMetrics is an optional extension of Metrics receiving the gauges, e.g. the usage of a MemoryBudget.
+type GaugeMetrics interface {
+	Metrics
+
+	// SetGauge sets the gauge with the name to the value.
+	SetGauge(name string, value int64)
+}
+
+// MemoryBudget bounds the estimated bytes held by the dynamic components sharing it, the HostCache, the
+// ReplayCache and the RemoteWhitelist, so the memory of the middleware can be capped on small containers.
+// The estimates count the strings and a fixed overhead per entry, not the exact heap usage. A nil budget
+// is unlimited.
+type MemoryBudget struct {
+	// used is accessed atomically and kept first for the 64-bit alignment
+	used  int64
+	limit int64
+
+	// Metrics receives MetricBudgetUsed and MetricBudgetRejected, no metrics are recorded if it is nil.
+	Metrics Metrics
+}
+
+// NewMemoryBudget returns a budget of limit bytes.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Limit returns the limit of the budget in bytes.
+func (b *MemoryBudget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// Used returns the bytes currently reserved from the budget.
+func (b *MemoryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.used)
+}
+
+// Reserve reserves n bytes, false is returned without reserving anything if they do not fit.
+func (b *MemoryBudget) Reserve(n int64) bool {
+	if b == nil {
+		return true
+	}
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if used+n > b.limit {
+			if b.Metrics != nil {
+				b.Metrics.IncCounter(MetricBudgetRejected)
+			}
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+n) {
+			b.report(used + n)
+			return true
+		}
+	}
+}
+
+// Release returns n bytes reserved before to the budget.
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.report(atomic.AddInt64(&b.used, -n))
+}
+
+// force reserves n bytes even if they do not fit, for the values which cannot be refused
+func (b *MemoryBudget) force(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.report(atomic.AddInt64(&b.used, n))
+}
+
+func (b *MemoryBudget) report(used int64) {
+	if m, ok := b.Metrics.(GaugeMetrics); ok {
+		m.SetGauge(MetricBudgetUsed, used)
+	}
+}
@@ -0,0 +1,15 @@
+package trustedproxy
+
+import "net"
+
+// Chain is a forwarded ip chain, ordered from the furthest hop to the nearest one.
+type Chain []net.IP
+
+// Strings returns the string form of the ips in the chain.
+func (c Chain) Strings() []string {
+	res := make([]string, len(c))
+	for i, ip := range c {
+		res[i] = ip.String()
+	}
+	return res
+}
@@ -0,0 +1,50 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ChainExtractor tries the extractors in order and uses the first one trusting the request, e.g. a header
+// extractor for the CDN followed by a CIDRWhitelist for the direct load balancer. The extractors failing with
+// an error are skipped. If none of them trusts the request, the result of the first one succeeding is used,
+// and the last error is returned if all of them fail.
+type ChainExtractor []IPExtractor
+
+func (c ChainExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return c.resolve(func(e IPExtractor) (net.IP, net.IP, []net.IP, error) {
+		return e.Resolve(remote, forwarded)
+	})
+}
+
+func (c ChainExtractor) ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return c.resolve(func(e IPExtractor) (net.IP, net.IP, []net.IP, error) {
+		return resolveRequest(e, r, remote, forwarded)
+	})
+}
+
+func (c ChainExtractor) resolve(fn func(e IPExtractor) (net.IP, net.IP, []net.IP, error)) (net.IP, net.IP, []net.IP, error) {
+	var fallbackRemote net.IP
+	var fallbackRest []net.IP
+	found := false
+	lastErr := fmt.Errorf("no extractor configured")
+	for i, e := range c {
+		proxy, remote, rest, err := fn(e)
+		if err != nil {
+			lastErr = fmt.Errorf("extractor %d: %w", i, err)
+			continue
+		}
+		if proxy != nil {
+			return proxy, remote, rest, nil
+		}
+		if !found {
+			found = true
+			fallbackRemote, fallbackRest = remote, rest
+		}
+	}
+	if found {
+		return nil, fallbackRemote, fallbackRest, nil
+	}
+	return nil, nil, nil, lastErr
+}
@@ -0,0 +1,41 @@
+package trustedproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+var (
+	// CtxKeyChain is the context key for the ip chain supplied by a preceding middleware, see NewContextWithChain.
+	CtxKeyChain = &contextKey{"chain"}
+)
+
+// ChainSource supplies the forwarded ip chain of a request from somewhere other than the forwarding headers,
+// e.g. the event of a serverless platform or a preceding middleware, the chain is then resolved by the
+// extractor as if it was read from the headers.
+type ChainSource interface {
+	// Chain returns the forwarded ips from the left to the right, without the immediate peer. It returns false
+	// if the source has no chain for the request, the chain is then read from the headers as usual.
+	Chain(r *http.Request) ([]net.IP, bool, error)
+}
+
+// ChainSourceFunc is a function implementing ChainSource.
+type ChainSourceFunc func(r *http.Request) ([]net.IP, bool, error)
+
+func (f ChainSourceFunc) Chain(r *http.Request) ([]net.IP, bool, error) {
+	return f(r)
+}
+
+// ContextChainSource supplies the chain stored in the request context by NewContextWithChain.
+type ContextChainSource struct{}
+
+func (ContextChainSource) Chain(r *http.Request) ([]net.IP, bool, error) {
+	ips, ok := r.Context().Value(CtxKeyChain).([]net.IP)
+	return cloneIPs(ips), ok, nil
+}
+
+// NewContextWithChain returns a copy of the context carrying the forwarded ips for ContextChainSource.
+func NewContextWithChain(ctx context.Context, ips []net.IP) context.Context {
+	return context.WithValue(ctx, CtxKeyChain, cloneIPs(ips))
+}
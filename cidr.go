@@ -0,0 +1,285 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// NewCIDRWhitelist returns a whitelist of the networks, a single ip is treated as a network with only that ip
+// and a network prefixed with "!" is excluded, e.g. NewCIDRWhitelist("10.0.0.0/8", "!10.5.0.0/16").
+func NewCIDRWhitelist(cidrs ...string) (*CIDRWhitelist, error) {
+	res := &CIDRWhitelist{Whitelist: make([]*net.IPNet, 0, len(cidrs))}
+	if err := res.add(cidrs); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// NewCIDRWhitelistFromIPNets returns a whitelist of copies of the networks, nil networks and networks
+// with a non-canonical mask are rejected.
+func NewCIDRWhitelistFromIPNets(nets []*net.IPNet) (*CIDRWhitelist, error) {
+	res := &CIDRWhitelist{Whitelist: make([]*net.IPNet, 0, len(nets))}
+	for i, n := range nets {
+		if n == nil {
+			return nil, fmt.Errorf("invalid cidr at index %d: nil network", i)
+		}
+		if _, ok := toPrefix(n); !ok {
+			return nil, fmt.Errorf("invalid cidr %q at index %d", n.String(), i)
+		}
+		res.Whitelist = append(res.Whitelist, &net.IPNet{
+			IP:   append(net.IP{}, n.IP...),
+			Mask: append(net.IPMask{}, n.Mask...),
+		})
+	}
+	return res, nil
+}
+
+// Prefixes returns a copy of the networks in the whitelist.
+func (c *CIDRWhitelist) Prefixes() []*net.IPNet {
+	res := make([]*net.IPNet, 0, len(c.Whitelist))
+	for _, n := range c.Whitelist {
+		res = append(res, &net.IPNet{
+			IP:   append(net.IP{}, n.IP...),
+			Mask: append(net.IPMask{}, n.Mask...),
+		})
+	}
+	return res
+}
+
+// ContainsPrefix returns true if every ip in the network is in the whitelist.
+func (c *CIDRWhitelist) ContainsPrefix(n *net.IPNet) bool {
+	p, ok := toPrefix(n)
+	if !ok {
+		return false
+	}
+	for _, q := range normalizePrefixes(c.prefixes()) {
+		if q.Bits() <= p.Bits() && q.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Union returns a new whitelist containing the ips in either c or o.
+func (c *CIDRWhitelist) Union(o *CIDRWhitelist) *CIDRWhitelist {
+	return fromPrefixes(normalizePrefixes(append(c.prefixes(), o.prefixes()...)))
+}
+
+// Intersect returns a new whitelist containing the ips in both c and o.
+func (c *CIDRWhitelist) Intersect(o *CIDRWhitelist) *CIDRWhitelist {
+	var res []netip.Prefix
+	for _, p := range c.prefixes() {
+		for _, q := range o.prefixes() {
+			switch {
+			case p.Bits() <= q.Bits() && p.Contains(q.Addr()):
+				res = append(res, q)
+			case q.Bits() <= p.Bits() && q.Contains(p.Addr()):
+				res = append(res, p)
+			}
+		}
+	}
+	return fromPrefixes(normalizePrefixes(res))
+}
+
+// Subtract returns a new whitelist containing the ips in c but not in o.
+func (c *CIDRWhitelist) Subtract(o *CIDRWhitelist) *CIDRWhitelist {
+	res := normalizePrefixes(c.prefixes())
+	for _, q := range o.prefixes() {
+		var next []netip.Prefix
+		for _, p := range res {
+			next = append(next, subtractPrefix(p, q)...)
+		}
+		res = next
+	}
+	return fromPrefixes(normalizePrefixes(res))
+}
+
+// MarshalText returns the comma separated networks of the whitelist in their canonical form,
+// sorted and with overlapping or adjacent networks merged, so two whitelists trusting the same
+// ips always produce the same text. The excluded networks follow in the same form prefixed with "!",
+// the text is then only canonical for the same networks and exclusions.
+func (c *CIDRWhitelist) MarshalText() ([]byte, error) {
+	var res []string
+	for _, p := range normalizePrefixes(toPrefixes(c.Whitelist)) {
+		res = append(res, p.String())
+	}
+	for _, p := range normalizePrefixes(toPrefixes(c.Exclude)) {
+		res = append(res, "!"+p.String())
+	}
+	return []byte(strings.Join(res, ",")), nil
+}
+
+// UnmarshalText parses the comma or whitespace separated networks into the whitelist, a single ip
+// is treated as a network with only that ip and a network prefixed with "!" is excluded.
+func (c *CIDRWhitelist) UnmarshalText(text []byte) error {
+	fields := strings.FieldsFunc(string(text), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	var res CIDRWhitelist
+	if err := res.add(fields); err != nil {
+		return err
+	}
+	*c = res
+	return nil
+}
+
+// add parses the networks into the whitelist, or the excluded networks if prefixed with "!"
+func (c *CIDRWhitelist) add(cidrs []string) error {
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		exclude := strings.HasPrefix(cidr, "!")
+		n, err := parseCIDR(strings.TrimPrefix(cidr, "!"))
+		if err != nil {
+			return err
+		}
+		if exclude {
+			c.Exclude = append(c.Exclude, n)
+		} else {
+			c.Whitelist = append(c.Whitelist, n)
+		}
+	}
+	return nil
+}
+
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid cidr %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %q: %w", s, err)
+	}
+	return n, nil
+}
+
+// prefixes returns the trusted networks, which are the whitelist with the excluded networks subtracted
+func (c *CIDRWhitelist) prefixes() []netip.Prefix {
+	res := toPrefixes(c.Whitelist)
+	for _, q := range toPrefixes(c.Exclude) {
+		var next []netip.Prefix
+		for _, p := range res {
+			next = append(next, subtractPrefix(p, q)...)
+		}
+		res = next
+	}
+	return res
+}
+
+func toPrefixes(nets []*net.IPNet) []netip.Prefix {
+	res := make([]netip.Prefix, 0, len(nets))
+	for _, n := range nets {
+		if p, ok := toPrefix(n); ok {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func toPrefix(n *net.IPNet) (netip.Prefix, bool) {
+	ones, bits := n.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}, false
+	}
+	ip := n.IP
+	if bits == 32 {
+		ip = ip.To4()
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
+
+func fromPrefixes(prefixes []netip.Prefix) *CIDRWhitelist {
+	res := &CIDRWhitelist{Whitelist: make([]*net.IPNet, 0, len(prefixes))}
+	for _, p := range prefixes {
+		res.Whitelist = append(res.Whitelist, &net.IPNet{
+			IP:   net.IP(p.Addr().AsSlice()),
+			Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen()),
+		})
+	}
+	return res
+}
+
+// normalizePrefixes sorts the prefixes, drops the ones covered by another and merges the siblings
+func normalizePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	sorted := append([]netip.Prefix{}, prefixes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if c := sorted[i].Addr().Compare(sorted[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return sorted[i].Bits() < sorted[j].Bits()
+	})
+	var res []netip.Prefix
+	for _, p := range sorted {
+		if len(res) > 0 {
+			last := res[len(res)-1]
+			if last.Addr().BitLen() == p.Addr().BitLen() && last.Contains(p.Addr()) {
+				continue
+			}
+		}
+		res = append(res, p)
+		// merge the sibling networks repeatedly, e.g. 10.0.0.0/25 + 10.0.0.128/25 = 10.0.0.0/24
+		for len(res) >= 2 {
+			a, b := res[len(res)-2], res[len(res)-1]
+			if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().BitLen() != b.Addr().BitLen() {
+				break
+			}
+			parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+			if parent.Addr() != a.Addr() || !parent.Contains(b.Addr()) {
+				break
+			}
+			res = append(res[:len(res)-2], parent)
+		}
+	}
+	return res
+}
+
+// subtractPrefix returns the prefixes covering p but not q
+func subtractPrefix(p netip.Prefix, q netip.Prefix) []netip.Prefix {
+	if p.Addr().BitLen() != q.Addr().BitLen() {
+		return []netip.Prefix{p}
+	}
+	if q.Bits() <= p.Bits() {
+		if q.Contains(p.Addr()) {
+			return nil
+		}
+		return []netip.Prefix{p}
+	}
+	if !p.Contains(q.Addr()) {
+		return []netip.Prefix{p}
+	}
+	var res []netip.Prefix
+	cur := p
+	for cur.Bits() < q.Bits() {
+		left := netip.PrefixFrom(cur.Addr(), cur.Bits()+1)
+		right := netip.PrefixFrom(setBit(cur.Addr(), cur.Bits()), cur.Bits()+1)
+		if left.Contains(q.Addr()) {
+			res = append(res, right)
+			cur = left
+		} else {
+			res = append(res, left)
+			cur = right
+		}
+	}
+	return res
+}
+
+// setBit returns the addr with the bit at the index (from the most significant bit) set
+func setBit(addr netip.Addr, index int) netip.Addr {
+	b := addr.AsSlice()
+	b[index/8] |= 0x80 >> (index % 8)
+	res, _ := netip.AddrFromSlice(b)
+	return res
+}
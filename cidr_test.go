@@ -0,0 +1,125 @@
+package trustedproxy
+
+import (
+	"net"
+	"testing"
+)
+
+func mustWhitelist(t *testing.T, cidrs ...string) *CIDRWhitelist {
+	t.Helper()
+	w, err := NewCIDRWhitelist(cidrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func marshalWhitelist(t *testing.T, w *CIDRWhitelist) string {
+	t.Helper()
+	text, err := w.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(text)
+}
+
+func TestCIDRWhitelistSetOperations(t *testing.T) {
+	tests := []struct {
+		name string
+		got  func() *CIDRWhitelist
+		want string
+	}{
+		{"union merges adjacent", func() *CIDRWhitelist {
+			return mustWhitelist(t, "10.0.0.0/25").Union(mustWhitelist(t, "10.0.0.128/25"))
+		}, "10.0.0.0/24"},
+		{"union keeps families", func() *CIDRWhitelist {
+			return mustWhitelist(t, "2001:db8::/32").Union(mustWhitelist(t, "192.0.2.0/24"))
+		}, "192.0.2.0/24,2001:db8::/32"},
+		{"intersect", func() *CIDRWhitelist {
+			return mustWhitelist(t, "10.0.0.0/8", "192.0.2.0/24").Intersect(mustWhitelist(t, "10.1.0.0/16", "198.51.100.0/24"))
+		}, "10.1.0.0/16"},
+		{"intersect disjoint", func() *CIDRWhitelist {
+			return mustWhitelist(t, "10.0.0.0/8").Intersect(mustWhitelist(t, "192.0.2.0/24"))
+		}, ""},
+		{"subtract", func() *CIDRWhitelist {
+			return mustWhitelist(t, "10.0.0.0/24").Subtract(mustWhitelist(t, "10.0.0.0/25"))
+		}, "10.0.0.128/25"},
+		{"subtract a single ip", func() *CIDRWhitelist {
+			return mustWhitelist(t, "10.0.0.0/30").Subtract(mustWhitelist(t, "10.0.0.1"))
+		}, "10.0.0.0/32,10.0.0.2/31"},
+		{"subtract everything", func() *CIDRWhitelist {
+			return mustWhitelist(t, "10.0.0.0/24").Subtract(mustWhitelist(t, "10.0.0.0/8"))
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := marshalWhitelist(t, tt.got()); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCIDRWhitelistText(t *testing.T) {
+	w := mustWhitelist(t, "10.0.1.0/24", "10.0.0.0/24", "10.0.0.7", "!10.0.0.5")
+	text := marshalWhitelist(t, w)
+	if text != "10.0.0.0/23,!10.0.0.5/32" {
+		t.Fatalf("marshaled %q", text)
+	}
+	var parsed CIDRWhitelist
+	if err := parsed.UnmarshalText([]byte("10.0.0.0/23\n !10.0.0.5/32")); err != nil {
+		t.Fatal(err)
+	}
+	if got := marshalWhitelist(t, &parsed); got != text {
+		t.Errorf("round trip %q, want %q", got, text)
+	}
+	if parsed.Contains(net.ParseIP("10.0.0.5")) || !parsed.Contains(net.ParseIP("10.0.1.9")) {
+		t.Error("the parsed whitelist does not match the networks")
+	}
+	if err := parsed.UnmarshalText([]byte("10.0.0.0/33")); err == nil {
+		t.Error("invalid network parsed")
+	}
+	if got := marshalWhitelist(t, &parsed); got != text {
+		t.Errorf("failed parse modified the whitelist to %q", got)
+	}
+}
+
+func TestCIDRWhitelistContainsPrefix(t *testing.T) {
+	w := mustWhitelist(t, "10.0.0.0/25", "10.0.0.128/25")
+	for cidr, want := range map[string]bool{
+		"10.0.0.0/24":   true,
+		"10.0.0.64/26":  true,
+		"10.0.0.0/23":   false,
+		"192.0.2.0/24":  false,
+		"::ffff:0:0/96": false,
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.ContainsPrefix(n); got != want {
+			t.Errorf("ContainsPrefix(%s) = %v, want %v", cidr, got, want)
+		}
+	}
+}
+
+func TestNewCIDRWhitelistFromIPNets(t *testing.T) {
+	_, n, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewCIDRWhitelistFromIPNets([]*net.IPNet{n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.IP[0] = 192
+	if !w.Contains(net.ParseIP("10.1.2.3")) {
+		t.Error("the whitelist shares the network")
+	}
+	if _, err := NewCIDRWhitelistFromIPNets([]*net.IPNet{nil}); err == nil {
+		t.Error("nil network accepted")
+	}
+	if _, err := NewCIDRWhitelistFromIPNets([]*net.IPNet{{IP: net.ParseIP("10.0.0.0"), Mask: net.IPMask{255, 0, 255, 0}}}); err == nil {
+		t.Error("non-canonical mask accepted")
+	}
+}
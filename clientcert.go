@@ -0,0 +1,275 @@
+package trustedproxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientCertInfo is the client certificate information forwarded by a trusted proxy, normalized from
+// either the Envoy X-Forwarded-Client-Cert header or the Traefik X-Forwarded-Tls-Client-Cert(-Info) headers.
+type ClientCertInfo struct {
+	// By is the identity of the proxy which received the certificate, only provided by Envoy.
+	By string
+
+	// Hash is the hex encoded SHA-256 digest of the DER encoded certificate.
+	Hash string
+
+	// Subject is the subject distinguished name of the certificate.
+	Subject string
+
+	// Issuer is the issuer distinguished name of the certificate.
+	Issuer string
+
+	// URI is the URI type subject alternative names of the certificate.
+	URI []string
+
+	// DNS is the DNS type subject alternative names of the certificate.
+	DNS []string
+
+	// NotBefore and NotAfter are the validity bounds of the certificate, zero if unknown.
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// Certificate is the parsed certificate, nil if the proxy did not forward the certificate itself.
+	Certificate *x509.Certificate
+}
+
+// ExtractClientCertInfo returns the client certificates from the Envoy X-Forwarded-Client-Cert header,
+// or from the Traefik X-Forwarded-Tls-Client-Cert and X-Forwarded-Tls-Client-Cert-Info headers when
+// the Envoy header is absent.
+func ExtractClientCertInfo(h *http.Header) ([]ClientCertInfo, error) {
+	if xfcc := h.Get("X-Forwarded-Client-Cert"); xfcc != "" {
+		return ParseXFCC(xfcc)
+	}
+	cert := h.Get("X-Forwarded-Tls-Client-Cert")
+	info := h.Get("X-Forwarded-Tls-Client-Cert-Info")
+	if cert == "" && info == "" {
+		return nil, nil
+	}
+	return ParseTraefikClientCert(cert, info)
+}
+
+// ParseXFCC parses the Envoy X-Forwarded-Client-Cert header value
+// see https://www.envoyproxy.io/docs/envoy/latest/configuration/http/http_conn_man/headers#x-forwarded-client-cert
+func ParseXFCC(value string) ([]ClientCertInfo, error) {
+	var res []ClientCertInfo
+	for _, element := range splitQuoted(value, ',') {
+		var info ClientCertInfo
+		for _, pair := range splitQuoted(element, ';') {
+			key, val, err := splitPair(pair)
+			if err != nil {
+				return nil, err
+			}
+			switch strings.ToLower(key) {
+			case "by":
+				info.By = val
+			case "hash":
+				info.Hash = strings.ToLower(val)
+			case "subject":
+				info.Subject = val
+			case "uri":
+				info.URI = append(info.URI, val)
+			case "dns":
+				info.DNS = append(info.DNS, val)
+			case "cert":
+				raw, err := url.QueryUnescape(val)
+				if err != nil {
+					return nil, fmt.Errorf("invalid xfcc cert: %w", err)
+				}
+				block, _ := pem.Decode([]byte(raw))
+				if block == nil {
+					return nil, fmt.Errorf("invalid xfcc cert: no pem block")
+				}
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, fmt.Errorf("invalid xfcc cert: %w", err)
+				}
+				info.Certificate = cert
+			}
+		}
+		if info.Certificate != nil {
+			info.fillFromCertificate()
+		}
+		res = append(res, info)
+	}
+	return res, nil
+}
+
+// ParseTraefikClientCert parses the Traefik X-Forwarded-Tls-Client-Cert and X-Forwarded-Tls-Client-Cert-Info
+// header values, either of them can be empty.
+// see https://doc.traefik.io/traefik/middlewares/http/passtlsclientcert/
+func ParseTraefikClientCert(cert string, info string) ([]ClientCertInfo, error) {
+	var res []ClientCertInfo
+	if cert != "" {
+		// base64 has no "%", a value without one is forwarded unescaped and its "+" is not a space
+		raw := cert
+		if strings.Contains(cert, "%") {
+			var err error
+			if raw, err = url.QueryUnescape(cert); err != nil {
+				return nil, fmt.Errorf("invalid traefik client cert: %w", err)
+			}
+		}
+		for _, part := range strings.Split(raw, ",") {
+			part = strings.NewReplacer(
+				"-----BEGIN CERTIFICATE-----", "",
+				"-----END CERTIFICATE-----", "",
+				"\n", "", "\r", "", " ", "",
+			).Replace(part)
+			if part == "" {
+				continue
+			}
+			der, err := base64.StdEncoding.DecodeString(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid traefik client cert: %w", err)
+			}
+			c, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("invalid traefik client cert: %w", err)
+			}
+			i := ClientCertInfo{Certificate: c}
+			i.fillFromCertificate()
+			res = append(res, i)
+		}
+	}
+	if info == "" {
+		return res, nil
+	}
+	raw, err := url.QueryUnescape(info)
+	if err != nil {
+		return nil, fmt.Errorf("invalid traefik client cert info: %w", err)
+	}
+	for index, element := range splitQuoted(raw, ',') {
+		var i ClientCertInfo
+		for _, pair := range splitQuoted(element, ';') {
+			key, val, err := splitPair(pair)
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "Subject":
+				i.Subject = val
+			case "Issuer":
+				i.Issuer = val
+			case "NB", "NA":
+				sec, err := strconv.ParseInt(val, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid traefik client cert info %s: %w", key, err)
+				}
+				if key == "NB" {
+					i.NotBefore = time.Unix(sec, 0)
+				} else {
+					i.NotAfter = time.Unix(sec, 0)
+				}
+			case "SAN":
+				// traefik does not tell the type of the alternative names
+				for _, san := range strings.Split(val, ",") {
+					if strings.Contains(san, "://") {
+						i.URI = append(i.URI, san)
+					} else if san != "" {
+						i.DNS = append(i.DNS, san)
+					}
+				}
+			}
+		}
+		// the certificate itself is more reliable than the info header, only fill in the blanks
+		if index < len(res) {
+			res[index].merge(i)
+		} else {
+			res = append(res, i)
+		}
+	}
+	return res, nil
+}
+
+func (i *ClientCertInfo) fillFromCertificate() {
+	c := i.Certificate
+	sum := sha256.Sum256(c.Raw)
+	i.Hash = hex.EncodeToString(sum[:])
+	if i.Subject == "" {
+		i.Subject = c.Subject.String()
+	}
+	i.Issuer = c.Issuer.String()
+	if len(i.URI) == 0 {
+		for _, u := range c.URIs {
+			i.URI = append(i.URI, u.String())
+		}
+	}
+	if len(i.DNS) == 0 {
+		i.DNS = append(i.DNS, c.DNSNames...)
+	}
+	i.NotBefore = c.NotBefore
+	i.NotAfter = c.NotAfter
+}
+
+func (i *ClientCertInfo) merge(o ClientCertInfo) {
+	if i.Subject == "" {
+		i.Subject = o.Subject
+	}
+	if i.Issuer == "" {
+		i.Issuer = o.Issuer
+	}
+	if len(i.URI) == 0 {
+		i.URI = o.URI
+	}
+	if len(i.DNS) == 0 {
+		i.DNS = o.DNS
+	}
+	if i.NotBefore.IsZero() {
+		i.NotBefore = o.NotBefore
+	}
+	if i.NotAfter.IsZero() {
+		i.NotAfter = o.NotAfter
+	}
+}
+
+// splitQuoted splits s by sep, ignoring the separators inside double quotes
+func splitQuoted(s string, sep byte) []string {
+	var res []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	res = append(res, s[start:])
+	return res
+}
+
+// splitPair splits a key=value pair and unquotes the value if it is quoted
+func splitPair(pair string) (string, string, error) {
+	key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid pair %q", pair)
+	}
+	if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+		var b strings.Builder
+		for i := 1; i < len(val)-1; i++ {
+			if val[i] == '\\' && i+1 < len(val)-1 {
+				i++
+			}
+			b.WriteByte(val[i])
+		}
+		val = b.String()
+	}
+	return key, val, nil
+}
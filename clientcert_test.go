@@ -0,0 +1,170 @@
+package trustedproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClientCert returns a self-signed client certificate with a DNS and an URI alternative name
+func testClientCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, _ := url.Parse("spiffe://example.com/client")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Unix(1700000000, 0),
+		NotAfter:     time.Unix(1800000000, 0),
+		DNSNames:     []string{"client.example.com"},
+		URIs:         []*url.URL{uri},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func certHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseXFCC(t *testing.T) {
+	cert := testClientCert(t)
+	escaped := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	garbage := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")})))
+	tests := []struct {
+		name    string
+		value   string
+		want    []ClientCertInfo
+		wantErr bool
+	}{
+		{"fields", `By=spiffe://example.com/proxy;Hash=ABCDEF;Subject="CN=client,O=Example";URI=spiffe://example.com/client;DNS=a.example.com;DNS=b.example.com`,
+			[]ClientCertInfo{{By: "spiffe://example.com/proxy", Hash: "abcdef", Subject: "CN=client,O=Example",
+				URI: []string{"spiffe://example.com/client"}, DNS: []string{"a.example.com", "b.example.com"}}}, false},
+		{"elements", `Hash=aa;Subject="CN=a";DNS=a.example.com,Hash=bb`,
+			[]ClientCertInfo{{Hash: "aa", Subject: "CN=a", DNS: []string{"a.example.com"}}, {Hash: "bb"}}, false},
+		{"url escaped pem", `Hash=ignored;Subject="CN=forwarded";Cert="` + escaped + `"`,
+			[]ClientCertInfo{{Hash: certHash(cert), Subject: "CN=forwarded", Issuer: "CN=client",
+				URI: []string{"spiffe://example.com/client"}, DNS: []string{"client.example.com"},
+				NotBefore: cert.NotBefore, NotAfter: cert.NotAfter, Certificate: cert}}, false},
+		{"missing value", `Hash`, nil, true},
+		{"invalid escape", `Cert="%zz"`, nil, true},
+		{"no pem block", `Cert="` + url.QueryEscape(base64.StdEncoding.EncodeToString(cert.Raw)) + `"`, nil, true},
+		{"not a certificate", `Cert="` + garbage + `"`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseXFCC(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTraefikClientCert(t *testing.T) {
+	cert := testClientCert(t)
+	der := base64.StdEncoding.EncodeToString(cert.Raw)
+	fromCert := ClientCertInfo{Hash: certHash(cert), Subject: "CN=client", Issuer: "CN=client",
+		URI: []string{"spiffe://example.com/client"}, DNS: []string{"client.example.com"},
+		NotBefore: cert.NotBefore, NotAfter: cert.NotAfter, Certificate: cert}
+	tests := []struct {
+		name    string
+		cert    string
+		info    string
+		want    []ClientCertInfo
+		wantErr bool
+	}{
+		{"raw base64 der", der, "", []ClientCertInfo{fromCert}, false},
+		{"url escaped base64 der", url.QueryEscape(der), "", []ClientCertInfo{fromCert}, false},
+		{"url escaped pem", url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))), "",
+			[]ClientCertInfo{fromCert}, false},
+		{"chain", der + "," + der, "", []ClientCertInfo{fromCert, fromCert}, false},
+		{"info only", "", url.QueryEscape(`Subject="CN=client,O=Example";Issuer="CN=ca";NB=1700000000;NA=1800000000;SAN="spiffe://example.com/client,client.example.com"`),
+			[]ClientCertInfo{{Subject: "CN=client,O=Example", Issuer: "CN=ca", NotBefore: time.Unix(1700000000, 0),
+				NotAfter: time.Unix(1800000000, 0), URI: []string{"spiffe://example.com/client"}, DNS: []string{"client.example.com"}}}, false},
+		{"certificate wins over info", der, url.QueryEscape(`Subject="CN=other";Issuer="CN=ca"`), []ClientCertInfo{fromCert}, false},
+		{"invalid escape", "%zz", "", nil, true},
+		{"not base64", "!!!", "", nil, true},
+		{"not a certificate", base64.StdEncoding.EncodeToString([]byte("garbage")), "", nil, true},
+		{"invalid info escape", "", "%zz", nil, true},
+		{"invalid info pair", "", "Subject", nil, true},
+		{"invalid info time", "", "NB=yesterday", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTraefikClientCert(tt.cert, tt.info)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCertInfoTrust(t *testing.T) {
+	h := &HTTPHandler{Extractor: mustWhitelist(t, "10.0.0.0/8")}
+	tests := []struct {
+		name   string
+		peer   string
+		header string
+		value  string
+		want   int
+	}{
+		{"trusted envoy", "10.0.0.2:4711", "X-Forwarded-Client-Cert", `Hash=aa;Subject="CN=client"`, 1},
+		{"trusted traefik", "10.0.0.2:4711", "X-Forwarded-Tls-Client-Cert-Info", url.QueryEscape(`Subject="CN=client"`), 1},
+		{"untrusted envoy", "203.0.113.9:4711", "X-Forwarded-Client-Cert", `Hash=aa;Subject="CN=client"`, 0},
+		{"untrusted traefik", "203.0.113.9:4711", "X-Forwarded-Tls-Client-Cert-Info", url.QueryEscape(`Subject="CN=client"`), 0},
+		{"untrusted malformed", "203.0.113.9:4711", "X-Forwarded-Client-Cert", "garbage", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.peer
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.Header.Set(tt.header, tt.value)
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, _ := FromContext(r.Context())
+				infos, err := f.GetClientCertInfo()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(infos) != tt.want {
+					t.Errorf("%d certificates, want %d", len(infos), tt.want)
+				}
+				if tt.want > 0 && !strings.HasPrefix(infos[0].Subject, "CN=client") {
+					t.Errorf("subject %q, want CN=client", infos[0].Subject)
+				}
+			}))
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package trustedproxy
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// CtxKeyClientHello is the context key for the ClientHello of a connection, see ClientHelloRecorder.
+	CtxKeyClientHello = &contextKey{"client-hello"}
+)
+
+// ClientHello is the TLS ClientHello metadata of a connection. It is sent by the immediate peer, so it only
+// describes the client when this server terminates the client TLS, e.g. behind a layer 4 load balancer.
+type ClientHello struct {
+	ServerName        string
+	ALPN              []string
+	SupportedVersions []uint16
+	CipherSuites      []uint16
+	SupportedCurves   []tls.CurveID
+	SupportedPoints   []uint8
+	SignatureSchemes  []tls.SignatureScheme
+
+	// Fingerprint is the hash of the ClientHello by ClientHelloRecorder.Hasher.
+	Fingerprint string
+}
+
+// ClientHelloHasher computes the fingerprint of a ClientHello.
+type ClientHelloHasher interface {
+	Hash(hello *tls.ClientHelloInfo) string
+}
+
+// ClientHelloHasherFunc is an adapter to allow the use of ordinary functions as ClientHelloHasher.
+type ClientHelloHasherFunc func(hello *tls.ClientHelloInfo) string
+
+// Hash calls f(hello).
+func (f ClientHelloHasherFunc) Hash(hello *tls.ClientHelloInfo) string {
+	return f(hello)
+}
+
+// DefaultClientHelloHasher is a JA3-style hasher, the hex encoded MD5 digest of the highest supported version,
+// the cipher suites, the curves and the point formats with the GREASE values removed. crypto/tls does not
+// expose the extensions of the ClientHello, so the fingerprints are not comparable with JA3 ones.
+var DefaultClientHelloHasher ClientHelloHasher = ClientHelloHasherFunc(func(hello *tls.ClientHelloInfo) string {
+	var version uint16
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+	ciphers := make([]uint16, 0, len(hello.CipherSuites))
+	for _, c := range hello.CipherSuites {
+		if !isGREASE(c) {
+			ciphers = append(ciphers, c)
+		}
+	}
+	curves := make([]uint16, 0, len(hello.SupportedCurves))
+	for _, c := range hello.SupportedCurves {
+		if !isGREASE(uint16(c)) {
+			curves = append(curves, uint16(c))
+		}
+	}
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, p := range hello.SupportedPoints {
+		points[i] = uint16(p)
+	}
+	s := strconv.Itoa(int(version)) + "," + joinUint16(ciphers) + "," + joinUint16(curves) + "," + joinUint16(points)
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+})
+
+// ClientHelloRecorder records the ClientHello of the TLS connections, so it is available to the handlers
+// through ForwardedRequest.GetClientHello next to the trusted values. GetConfigForClient must be set as the
+// tls.Config.GetConfigForClient, and ConnContext and ConnState as the ones of the http.Server.
+type ClientHelloRecorder struct {
+	// Hasher computes ClientHello.Fingerprint, DefaultClientHelloHasher is used if it is nil.
+	Hasher ClientHelloHasher
+
+	// Next is the GetConfigForClient called after recording, nil keeps the tls.Config of the server.
+	Next func(hello *tls.ClientHelloInfo) (*tls.Config, error)
+
+	conns sync.Map
+}
+
+// clientHelloSlot is filled during the handshake, which completes before any request of the connection
+// is served
+type clientHelloSlot struct {
+	hello *ClientHello
+}
+
+// GetConfigForClient records the ClientHello, then calls Next.
+func (c *ClientHelloRecorder) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if slot, ok := c.conns.LoadAndDelete(hello.Conn); ok {
+		slot.(*clientHelloSlot).hello = c.record(hello)
+	}
+	if c.Next != nil {
+		return c.Next(hello)
+	}
+	return nil, nil
+}
+
+// ConnContext prepares the context of the TLS connections to carry their ClientHello, it is a no-op for
+// other connections.
+func (c *ClientHelloRecorder) ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return ctx
+	}
+	slot := &clientHelloSlot{}
+	c.conns.Store(tc.NetConn(), slot)
+	return context.WithValue(ctx, CtxKeyClientHello, slot)
+}
+
+// ConnState releases the connections closed before sending a ClientHello.
+func (c *ClientHelloRecorder) ConnState(conn net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		c.conns.Delete(tc.NetConn())
+	}
+}
+
+func (c *ClientHelloRecorder) record(hello *tls.ClientHelloInfo) *ClientHello {
+	hasher := c.Hasher
+	if hasher == nil {
+		hasher = DefaultClientHelloHasher
+	}
+	return &ClientHello{
+		ServerName:        hello.ServerName,
+		ALPN:              append([]string{}, hello.SupportedProtos...),
+		SupportedVersions: append([]uint16{}, hello.SupportedVersions...),
+		CipherSuites:      append([]uint16{}, hello.CipherSuites...),
+		SupportedCurves:   append([]tls.CurveID{}, hello.SupportedCurves...),
+		SupportedPoints:   append([]uint8{}, hello.SupportedPoints...),
+		SignatureSchemes:  append([]tls.SignatureScheme{}, hello.SignatureSchemes...),
+		Fingerprint:       hasher.Hash(hello),
+	}
+}
+
+// isGREASE returns true if the value is reserved by RFC 8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func joinUint16(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, "-")
+}
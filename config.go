@@ -0,0 +1,154 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// EffectiveConfig is a snapshot of the configuration of an HTTPHandler with the secrets redacted, for support
+// bundles and debug endpoints answering "what is this process actually trusting right now".
+type EffectiveConfig struct {
+	// Extractor is the type of the extractor, e.g. "*trustedproxy.CIDRWhitelist".
+	Extractor string
+
+	// ExtractorConfig is the text form of the extractor if it implements encoding.TextMarshaler,
+	// e.g. the trusted networks of a CIDRWhitelist.
+	ExtractorConfig string `json:",omitempty"`
+
+	// ChainSource is the type of the chain source, empty if the chain is only read from the headers.
+	ChainSource string `json:",omitempty"`
+
+	Strategy           string
+	HeaderMode         string
+	Headers            HeaderConfig
+	StrictParsing      bool
+	TolerantParsing    bool
+	RejectFolded       bool
+	RejectConflicting  bool
+	RightmostValues    bool
+	UnknownHops        string
+	TrustedEdgeHeaders []string `json:",omitempty"`
+	NoProtoDowngrade   bool
+	ResultHeader       string `json:",omitempty"`
+
+	// ResultTrust is the type of the extractor trusting the ResultHeader, empty if no result is adopted.
+	ResultTrust string `json:",omitempty"`
+
+	// PeerCred is the unix socket peer policy, nil if unix socket peers are rejected.
+	PeerCred *PeerCredPolicy `json:",omitempty"`
+
+	// SignatureMode, SignatureKeyIDs and ReplayCacheSize describe the signature verification, only the ids
+	// of the keys are reported. SignatureMode is empty if the signature is not verified.
+	SignatureMode   string   `json:",omitempty"`
+	SignatureKeyIDs []string `json:",omitempty"`
+	ReplayCacheSize int      `json:",omitempty"`
+
+	// HostCacheSize is the size of the host cache, 0 if there is no cache.
+	HostCacheSize int `json:",omitempty"`
+
+	SoftFail bool
+
+	// Hooks is the names of the configured hooks.
+	Hooks           []string `json:",omitempty"`
+	HookErrorsFatal bool
+
+	Profile bool
+}
+
+// EffectiveConfig returns the configuration the handler is running with, with the secrets redacted.
+func (h *HTTPHandler) EffectiveConfig() EffectiveConfig {
+	cfg := EffectiveConfig{
+		Extractor:          fmt.Sprintf("%T", h.Extractor),
+		Strategy:           h.Strategy.String(),
+		HeaderMode:         h.HeaderMode.String(),
+		Headers:            *h.headerConfig(),
+		StrictParsing:      h.StrictParsing,
+		TolerantParsing:    h.TolerantParsing,
+		RejectFolded:       h.RejectFolded,
+		RejectConflicting:  h.RejectConflicting,
+		RightmostValues:    h.RightmostValues,
+		UnknownHops:        h.UnknownHops.String(),
+		TrustedEdgeHeaders: append([]string{}, h.TrustedEdgeHeaders...),
+		NoProtoDowngrade:   h.NoProtoDowngrade,
+		ResultHeader:       h.ResultHeader,
+		SoftFail:           h.SoftFail,
+		HookErrorsFatal:    h.HookErrorsFatal,
+		Profile:            h.Profile,
+	}
+	cfg.Headers.SSL = append([]string{}, cfg.Headers.SSL...)
+	if m, ok := h.Extractor.(interface{ MarshalText() ([]byte, error) }); ok {
+		if text, err := m.MarshalText(); err == nil {
+			cfg.ExtractorConfig = string(text)
+		}
+	}
+	if h.ResultTrust != nil {
+		cfg.ResultTrust = fmt.Sprintf("%T", h.ResultTrust)
+	}
+	if h.ChainSource != nil {
+		cfg.ChainSource = fmt.Sprintf("%T", h.ChainSource)
+	}
+	if h.PeerCred != nil {
+		cfg.PeerCred = &PeerCredPolicy{
+			PIDs: append([]int32{}, h.PeerCred.PIDs...),
+			UIDs: append([]uint32{}, h.PeerCred.UIDs...),
+			GIDs: append([]uint32{}, h.PeerCred.GIDs...),
+		}
+	}
+	if h.Verifier != nil {
+		cfg.SignatureMode = h.SignatureMode.String()
+		if h.Verifier.KeySet != nil {
+			cfg.SignatureKeyIDs = h.Verifier.KeySet.IDs()
+		} else {
+			for id := range h.Verifier.Keys {
+				cfg.SignatureKeyIDs = append(cfg.SignatureKeyIDs, id)
+			}
+			sort.Strings(cfg.SignatureKeyIDs)
+		}
+		if h.Verifier.Replay != nil {
+			cfg.ReplayCacheSize = h.Verifier.Replay.size
+		}
+	}
+	if h.HostCache != nil {
+		cfg.HostCacheSize = h.HostCache.size
+	}
+	if h.OnResolve != nil {
+		cfg.Hooks = append(cfg.Hooks, "OnResolve")
+	}
+	if h.OnDegraded != nil {
+		cfg.Hooks = append(cfg.Hooks, "OnDegraded")
+	}
+	if h.ForwardHook != nil {
+		cfg.Hooks = append(cfg.Hooks, "ForwardHook")
+	}
+	if h.OnHeaderMismatch != nil {
+		cfg.Hooks = append(cfg.Hooks, "OnHeaderMismatch")
+	}
+	for i := range h.Heuristics {
+		cfg.Hooks = append(cfg.Hooks, fmt.Sprintf("Heuristics[%d]", i))
+	}
+	return cfg
+}
+
+func (m HeaderMode) String() string {
+	switch m {
+	case HeaderModeXForwarded:
+		return "x-forwarded"
+	case HeaderModeForwarded:
+		return "forwarded"
+	case HeaderModePreferForwarded:
+		return "prefer-forwarded"
+	case HeaderModeCrossCheck:
+		return "cross-check"
+	}
+	return fmt.Sprintf("HeaderMode(%d)", uint(m))
+}
+
+func (m SignatureMode) String() string {
+	switch m {
+	case SignatureSufficient:
+		return "sufficient"
+	case SignatureRequired:
+		return "required"
+	}
+	return fmt.Sprintf("SignatureMode(%d)", uint(m))
+}
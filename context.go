@@ -1,5 +1,10 @@
 package trustedproxy
 
+import (
+	"net"
+	"net/http"
+)
+
 type contextKey struct {
 	name string
 }
@@ -8,3 +13,37 @@ var (
 	// CtxKeyForwardedRequest is the context key for the forwarded request.
 	CtxKeyForwardedRequest = &contextKey{"forwarded-request"}
 )
+
+// Host returns the trusted host of the request, r.Host is returned if the request does not
+// carry a ForwardedRequest in its context.
+func Host(r *http.Request) string {
+	if fr, ok := FromContext(r.Context()); ok {
+		return fr.GetTrustedHost()
+	}
+	return r.Host
+}
+
+// Proto returns the trusted protocol of the request, the protocol of the connection is returned if
+// the request does not carry a ForwardedRequest in its context.
+func Proto(r *http.Request) string {
+	if fr, ok := FromContext(r.Context()); ok {
+		return fr.GetTrustedProto()
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// ClientIP returns the trusted remote address of the request, the ip of r.RemoteAddr is returned if
+// the request does not carry a ForwardedRequest in its context, nil if it is not a valid address.
+func ClientIP(r *http.Request) net.IP {
+	if fr, ok := FromContext(r.Context()); ok {
+		return fr.GetTrustedRemoteAddr()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,127 @@
+package trustedproxy
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type corpusSample struct {
+	Name      string
+	Source    string
+	Extractor struct {
+		Type   string
+		Cidrs  []string
+		Header string
+	}
+	HeaderMode string `json:"header_mode"`
+	Tolerant   bool
+	Peer       string
+	Host       string
+	TLS        bool
+	Headers    []struct {
+		Name  string
+		Value string
+	}
+	Expect struct {
+		Proxy  *string
+		Remote string
+		Rest   []string
+		Host   string
+		Proto  string
+		Port   int
+	}
+}
+
+// ipStrings returns the text form of the ips, never nil so it compares equal to an empty expectation
+func ipStrings(ips []net.IP) []string {
+	res := []string{}
+	for _, ip := range ips {
+		res = append(res, hopNode(ip))
+	}
+	return res
+}
+
+func TestCorpus(t *testing.T) {
+	var corpus struct {
+		Version int
+		Samples []corpusSample
+	}
+	b, err := os.ReadFile("testdata/corpus.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &corpus); err != nil {
+		t.Fatal(err)
+	}
+	if corpus.Version != 1 {
+		t.Fatalf("unsupported corpus version %d", corpus.Version)
+	}
+	for _, s := range corpus.Samples {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			whitelist, err := NewCIDRWhitelist(s.Extractor.Cidrs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &HTTPHandler{Extractor: whitelist, TolerantParsing: s.Tolerant}
+			switch s.Extractor.Type {
+			case "cidr":
+			case "trusted_header":
+				h.Extractor = &TrustedHeaderExtractor{Header: s.Extractor.Header, Peers: whitelist}
+			default:
+				t.Fatalf("unknown extractor type %q", s.Extractor.Type)
+			}
+			switch s.HeaderMode {
+			case "", "x-forwarded":
+			case "forwarded":
+				h.HeaderMode = HeaderModeForwarded
+			default:
+				t.Fatalf("unknown header mode %q", s.HeaderMode)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://"+s.Host+"/", nil)
+			r.RemoteAddr = net.JoinHostPort(s.Peer, "50000")
+			if s.TLS {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for _, line := range s.Headers {
+				r.Header[line.Name] = append(r.Header[line.Name], line.Value)
+			}
+			var got Result
+			served := false
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, served = ResultFromContext(r.Context())
+			}))
+			if !served {
+				t.Fatalf("the request from %s failed", s.Source)
+			}
+
+			proxy := "null"
+			if got.ProxyIP != nil {
+				proxy = got.ProxyIP.String()
+			}
+			wantProxy := "null"
+			if s.Expect.Proxy != nil {
+				wantProxy = *s.Expect.Proxy
+			}
+			if proxy != wantProxy {
+				t.Errorf("proxy is %s, want %s", proxy, wantProxy)
+			}
+			if got.RemoteAddr.String() != s.Expect.Remote {
+				t.Errorf("remote is %v, want %s", got.RemoteAddr, s.Expect.Remote)
+			}
+			if rest, want := ipStrings(got.ForwardedFor), append([]string{}, s.Expect.Rest...); !reflect.DeepEqual(rest, want) {
+				t.Errorf("rest is %v, want %v", rest, want)
+			}
+			if got.Host != s.Expect.Host || got.Proto != s.Expect.Proto || got.Port != s.Expect.Port {
+				t.Errorf("host, proto and port are %q %q %d, want %q %q %d", got.Host, got.Proto, got.Port, s.Expect.Host, s.Expect.Proto, s.Expect.Port)
+			}
+		})
+	}
+}
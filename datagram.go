@@ -0,0 +1,66 @@
+package trustedproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// DatagramError is the error of ResolveDatagram, Type is the ErrorType a request failing the same way
+// is handled with.
+type DatagramError struct {
+	Type ErrorType
+	Err  error
+}
+
+func (e *DatagramError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DatagramError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveDatagram runs the trust resolution of the handler for a flow which is not bound to an http request,
+// e.g. a MASQUE CONNECT-UDP or WebTransport session proxied by an HTTP/3 gateway, so the datagrams carry
+// the same verified client identity as the requests. Peer is the remote address of the QUIC connection and
+// header the forwarding metadata of the flow, usually the header of the extended CONNECT request opening it.
+// The resolution is the one of the requests: the ChainSource, the header parsing and cross check, the
+// Extractor, Verifier, SoftFail, Strategy and RejectConflicting apply, only the events, the error handler and
+// the hooks other than OnHeaderMismatch do not run. Only the ip related fields, the PeerPort and Degraded of
+// the result are filled. The errors are *DatagramError.
+func (h *HTTPHandler) ResolveDatagram(ctx context.Context, peer net.Addr, header http.Header) (Result, error) {
+	if peer == nil {
+		return Result{}, &DatagramError{Type: ErrTypeUnknownRemoteAddr, Err: errors.New("missing peer address")}
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	r := (&http.Request{
+		Method:     http.MethodConnect,
+		Proto:      "HTTP/3.0",
+		ProtoMajor: 3,
+		Header:     header.Clone(),
+		RemoteAddr: peer.String(),
+	}).WithContext(ctx)
+	if err := normalizeForwardHeaders(r.Header, h.headerConfig().names(), h.RejectFolded); err != nil {
+		return Result{}, &DatagramError{Type: ErrTypeMalformedHeader, Err: err}
+	}
+	ips, _, errType, err := h.parseChain(r)
+	if err != nil {
+		return Result{}, &DatagramError{Type: errType, Err: err}
+	}
+	trust, errType, err := h.resolveTrust(r, ips)
+	if err != nil {
+		return Result{}, &DatagramError{Type: errType, Err: err}
+	}
+	return Result{
+		ProxyIP:      cloneIP(trust.proxy),
+		RemoteAddr:   cloneIP(trust.remote),
+		ForwardedFor: cloneIPs(withUnknownHopIP(trust.rest)),
+		Proxies:      cloneIPs(trust.proxies),
+		PeerPort:     peerPort(r),
+		Degraded:     trust.degraded != nil,
+	}, nil
+}
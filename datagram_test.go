@@ -0,0 +1,84 @@
+package trustedproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResolveDatagramMatchesRequests(t *testing.T) {
+	cdn := mustWhitelist(t, "10.0.0.0/8")
+	mismatch := func(r *http.Request, forwarded []net.IP, xff []net.IP) error { return errors.New("mismatch") }
+	tests := []struct {
+		name    string
+		handler *HTTPHandler
+		header  http.Header
+		fails   bool
+		remote  string
+	}{
+		{"trusted chain", &HTTPHandler{Extractor: cdn},
+			http.Header{"X-Forwarded-For": {"192.0.2.66, 203.0.113.7, 10.0.0.5"}}, false, "203.0.113.7"},
+		{"leftmost public", &HTTPHandler{Extractor: cdn, Strategy: StrategyLeftmostPublic},
+			http.Header{"X-Forwarded-For": {"198.51.100.1, 10.1.0.1, 203.0.113.7"}}, false, "198.51.100.1"},
+		{"soft fail", &HTTPHandler{Extractor: TrustDepth(3), SoftFail: true},
+			http.Header{"X-Forwarded-For": {"203.0.113.7"}}, false, "10.0.0.2"},
+		{"extractor error", &HTTPHandler{Extractor: TrustDepth(3)},
+			http.Header{"X-Forwarded-For": {"203.0.113.7"}}, true, ""},
+		{"strict parsing", &HTTPHandler{Extractor: cdn, StrictParsing: true},
+			http.Header{"X-Forwarded-For": {"203.0.113.7, garbage"}}, true, ""},
+		{"conflicting values", &HTTPHandler{Extractor: cdn, RejectConflicting: true},
+			http.Header{"X-Forwarded-For": {"203.0.113.7"}, "X-Forwarded-Proto": {"https", "http"}}, true, ""},
+		{"fatal cross check", &HTTPHandler{Extractor: cdn, HeaderMode: HeaderModeCrossCheck, OnHeaderMismatch: mismatch, HookErrorsFatal: true},
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}, "Forwarded": {"for=203.0.113.7"}}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want Result
+			var wantType ErrorType
+			failed := false
+			h := *tt.handler
+			h.ErrorHandler = func(et ErrorType, err error, w http.ResponseWriter, r *http.Request) {
+				failed, wantType = true, et
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = "10.0.0.2:4711"
+			r.Header = tt.header.Clone()
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				want, _ = ResultFromContext(r.Context())
+			}))
+			got, err := h.ResolveDatagram(context.Background(), &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 4711}, tt.header)
+			if failed != tt.fails {
+				t.Fatalf("request failed %v, want %v", failed, tt.fails)
+			}
+			if failed {
+				var de *DatagramError
+				if !errors.As(err, &de) || de.Type != wantType {
+					t.Errorf("datagram error %v, want error type %v", err, wantType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.ProxyIP.Equal(want.ProxyIP) || !got.RemoteAddr.Equal(want.RemoteAddr) ||
+				!reflect.DeepEqual(ipStrings(got.ForwardedFor), ipStrings(want.ForwardedFor)) ||
+				!reflect.DeepEqual(ipStrings(got.Proxies), ipStrings(want.Proxies)) ||
+				got.PeerPort != want.PeerPort || got.Degraded != want.Degraded || got.RemoteAddr.String() != tt.remote {
+				t.Errorf("datagram resolved %+v, request %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestResolveDatagramNilPeer(t *testing.T) {
+	h := &HTTPHandler{Extractor: TrustNone{}}
+	_, err := h.ResolveDatagram(context.Background(), nil, nil)
+	var de *DatagramError
+	if !errors.As(err, &de) || de.Type != ErrTypeUnknownRemoteAddr {
+		t.Errorf("error %v, want ErrTypeUnknownRemoteAddr", err)
+	}
+}
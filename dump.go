@@ -0,0 +1,57 @@
+package trustedproxy
+
+import (
+	"net"
+	"net/http/httputil"
+)
+
+// RedactedValue is the value of the masked headers in DumpTrustedRequest.
+const RedactedValue = "[REDACTED]"
+
+// DumpOptions controls the output of DumpTrustedRequest.
+type DumpOptions struct {
+	// Body includes the body of the request, it is read into memory on a copy so the trusted request
+	// shared by the readers of the snapshot is not modified, the body itself is consumed.
+	Body bool
+
+	// MaskClientIP masks the trusted remote address to its /24 (IPv4) or /48 (IPv6) network.
+	MaskClientIP bool
+
+	// MaskHeaders is the list of headers to mask in addition to the forwarding headers, e.g. Cookie.
+	MaskHeaders []string
+}
+
+// DumpTrustedRequest returns the httputil.DumpRequest output of the trusted request, safe to be attached to
+// support bundles: the raw forwarding headers are masked, and the trusted remote address is added as
+// the X-Trusted-Remote-Addr pseudo header, with the chain fingerprint as X-Trusted-Chain-Fingerprint.
+func DumpTrustedRequest(fr ForwardedRequest, opts DumpOptions) ([]byte, error) {
+	trusted := fr.GetTrustedRequest()
+	req := trusted.Clone(trusted.Context())
+	for name := range fr.GetOriginalForwardHeaders() {
+		if req.Header.Get(name) != "" {
+			req.Header.Set(name, RedactedValue)
+		}
+	}
+	for _, name := range opts.MaskHeaders {
+		if req.Header.Get(name) != "" {
+			req.Header.Set(name, RedactedValue)
+		}
+	}
+	remote := fr.GetTrustedRemoteAddr()
+	if opts.MaskClientIP {
+		remote = maskIP(remote)
+	}
+	req.Header.Set("X-Trusted-Remote-Addr", remote.String())
+	if fp := fr.GetResult().ChainFingerprint(); fp != "" {
+		req.Header.Set("X-Trusted-Chain-Fingerprint", fp)
+	}
+	// DumpRequest restores the body on the clone, never the one of the shared trusted request
+	return httputil.DumpRequest(req, opts.Body)
+}
+
+func maskIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32))
+	}
+	return ip.Mask(net.CIDRMask(48, 128))
+}
@@ -0,0 +1,40 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDumpTrustedRequest(t *testing.T) {
+	h := &HTTPHandler{Extractor: mustWhitelist(t, "10.0.0.0/8")}
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader("payload"))
+	r.RemoteAddr = "10.0.0.2:4711"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+	r.Header.Set("Cookie", "session=secret")
+	var dump []byte
+	var err error
+	h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _ := FromContext(r.Context())
+		trusted := f.GetTrustedRequest()
+		body := trusted.Body
+		dump, err = DumpTrustedRequest(f, DumpOptions{Body: true, MaskClientIP: true, MaskHeaders: []string{"Cookie"}})
+		if trusted.Body != body {
+			t.Error("the body of the shared trusted request is replaced")
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"X-Forwarded-For: " + RedactedValue + "\r\n",
+		"Cookie: " + RedactedValue + "\r\n",
+		"X-Trusted-Remote-Addr: 203.0.113.0\r\n",
+		"\r\n\r\npayload",
+	} {
+		if !strings.Contains(string(dump), want) {
+			t.Errorf("%q missing in the dump:\n%s", want, dump)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDuplicateForwardHeaders(t *testing.T) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		rightmost   bool
+		conflicting bool
+		host        []string
+		proto       []string
+		port        []string
+		wantHost    string
+		wantProto   string
+		wantPort    int
+		wantErr     bool
+	}{
+		{"single lines", false, false, []string{"a.example"}, []string{"https"}, []string{"8443"}, "a.example", "https", 8443, false},
+		{"first line by default", false, false, []string{"a.example", "b.example"}, []string{"https", "http"}, []string{"8443", "9443"}, "a.example", "https", 8443, false},
+		{"rightmost line", true, false, []string{"a.example", "b.example"}, []string{"https", "http"}, []string{"8443", "9443"}, "b.example", "http", 9443, false},
+		{"rightmost comma value", true, false, []string{"a.example, b.example"}, []string{"http,https"}, []string{"8443 , 9443"}, "b.example", "https", 9443, false},
+		{"rightmost skips empty values", true, false, []string{"a.example,", ""}, []string{"https"}, nil, "a.example", "https", 443, false},
+		{"conflicting lines", false, true, []string{"a.example", "b.example"}, []string{"https"}, nil, "", "", 0, true},
+		{"conflicting comma values", true, true, []string{"a.example"}, []string{"https, http"}, nil, "", "", 0, true},
+		{"agreeing duplicates", true, true, []string{"a.example", "A.example"}, []string{"https", "https"}, nil, "A.example", "https", 443, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errType ErrorType
+			h := &HTTPHandler{
+				Extractor:         cdn,
+				RightmostValues:   tt.rightmost,
+				RejectConflicting: tt.conflicting,
+				ErrorHandler: func(et ErrorType, err error, w http.ResponseWriter, r *http.Request) {
+					errType = et
+				},
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://backend.internal/", nil)
+			r.RemoteAddr = "10.0.0.2:4711"
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.Header["X-Forwarded-Host"] = tt.host
+			r.Header["X-Forwarded-Proto"] = tt.proto
+			if tt.port != nil {
+				r.Header["X-Forwarded-Port"] = tt.port
+			}
+			var host, proto string
+			var port int
+			called := false
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				f, _ := FromContext(r.Context())
+				host, proto, port = f.GetTrustedHost(), f.GetTrustedProto(), f.GetTrustedPort()
+			}))
+			if tt.wantErr {
+				if called || errType != ErrTypeMalformedHeader {
+					t.Errorf("called %v with error type %v, want ErrTypeMalformedHeader", called, errType)
+				}
+				return
+			}
+			if !called {
+				t.Fatalf("failed with error type %v", errType)
+			}
+			if host != tt.wantHost || proto != tt.wantProto || port != tt.wantPort {
+				t.Errorf("resolved %q %q %d, want %q %q %d", host, proto, port, tt.wantHost, tt.wantProto, tt.wantPort)
+			}
+		})
+	}
+}
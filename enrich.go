@@ -0,0 +1,129 @@
+package trustedproxy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Enricher looks up extra information about the trusted remote address, e.g. GeoIP, classification or PTR.
+type Enricher interface {
+	Enrich(ctx context.Context, ip net.IP) (any, error)
+}
+
+// EnricherFunc is an adapter to allow the use of ordinary functions as Enricher.
+type EnricherFunc func(ctx context.Context, ip net.IP) (any, error)
+
+func (f EnricherFunc) Enrich(ctx context.Context, ip net.IP) (any, error) {
+	return f(ctx, ip)
+}
+
+const (
+	// DefaultSubnetCacheIPv4Bits and DefaultSubnetCacheIPv6Bits is the subnet size of SubnetCache when
+	// the bits are zero.
+	DefaultSubnetCacheIPv4Bits = 24
+	DefaultSubnetCacheIPv6Bits = 56
+
+	// DefaultSubnetCacheSize is the max number of subnets of SubnetCache when Size is zero.
+	DefaultSubnetCacheSize = 65536
+)
+
+// SubnetCache is an Enricher calling the underlying Enricher once per client subnet per TTL, cutting the
+// lookup volume of large consumer ISP populations where a subnet shares the same enrichment. The errors
+// are not cached.
+type SubnetCache struct {
+	Enricher Enricher
+	TTL      time.Duration
+
+	// IPv4Bits and IPv6Bits is the prefix length of the subnets.
+	IPv4Bits int
+	IPv6Bits int
+
+	// Size is the max number of cached subnets, the expired subnets are evicted first when it is full.
+	Size int
+
+	mu      sync.Mutex
+	entries map[netip.Prefix]subnetEntry
+}
+
+type subnetEntry struct {
+	value   any
+	expires time.Time
+}
+
+// NewSubnetCache returns a SubnetCache of the enricher with the default subnet sizes.
+func NewSubnetCache(e Enricher, ttl time.Duration) *SubnetCache {
+	return &SubnetCache{Enricher: e, TTL: ttl}
+}
+
+func (c *SubnetCache) Enrich(ctx context.Context, ip net.IP) (any, error) {
+	key, ok := c.subnet(ip)
+	if !ok {
+		return c.Enricher.Enrich(ctx, ip)
+	}
+	now := time.Now()
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	c.mu.Unlock()
+	if found && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+	value, err := c.Enricher.Enrich(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[netip.Prefix]subnetEntry{}
+	}
+	if size := c.size(); len(c.entries) >= size {
+		c.evict(now, size)
+	}
+	c.entries[key] = subnetEntry{value: value, expires: now.Add(c.TTL)}
+	return value, nil
+}
+
+func (c *SubnetCache) subnet(ip net.IP) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	bits := c.IPv6Bits
+	if bits == 0 {
+		bits = DefaultSubnetCacheIPv6Bits
+	}
+	if addr.Is4() {
+		bits = c.IPv4Bits
+		if bits == 0 {
+			bits = DefaultSubnetCacheIPv4Bits
+		}
+	}
+	p, err := addr.Prefix(bits)
+	return p, err == nil
+}
+
+func (c *SubnetCache) size() int {
+	if c.Size <= 0 {
+		return DefaultSubnetCacheSize
+	}
+	return c.Size
+}
+
+// evict removes the expired entries, or an arbitrary one if none of them is expired
+func (c *SubnetCache) evict(now time.Time, size int) {
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < size {
+			return
+		}
+		delete(c.entries, k)
+	}
+}
@@ -0,0 +1,70 @@
+package trustedproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnricherIsolation(t *testing.T) {
+	errLookup := errors.New("no record")
+	tests := []struct {
+		name     string
+		enrich   EnricherFunc
+		value    any
+		lookup   error
+		panicked bool
+	}{
+		{"value", func(ctx context.Context, ip net.IP) (any, error) { return ip.String(), nil }, "203.0.113.7", nil, false},
+		{"lookup error", func(ctx context.Context, ip net.IP) (any, error) { return nil, errLookup }, nil, errLookup, false},
+		{"panic", func(ctx context.Context, ip net.IP) (any, error) { panic("geoip database closed") }, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &counterMetrics{}
+			var reported []error
+			h := &HTTPHandler{
+				Extractor:   TrustDepth(1),
+				Enricher:    tt.enrich,
+				Metrics:     m,
+				OnHookError: func(err error) { reported = append(reported, err) },
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = "10.0.0.2:4711"
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			var value any
+			var err error
+			served := false
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, _ := FromContext(r.Context())
+				value, err = f.GetEnrichment()
+				if again, againErr := f.GetEnrichment(); again != value || againErr != err {
+					t.Errorf("second lookup returned %v %v", again, againErr)
+				}
+				served = true
+			}))
+			if !served {
+				t.Fatal("the downstream handler did not finish")
+			}
+			if value != tt.value {
+				t.Errorf("value %v, want %v", value, tt.value)
+			}
+			var hookErr *HookError
+			if !tt.panicked {
+				if err != tt.lookup || len(reported) != 0 || m.count(MetricHookError) != 0 {
+					t.Errorf("error %v, reported %v, want %v", err, reported, tt.lookup)
+				}
+				return
+			}
+			if !errors.As(err, &hookErr) || !hookErr.Panicked || hookErr.Hook != "Enricher" {
+				t.Fatalf("error %v, want a panicked Enricher HookError", err)
+			}
+			if len(reported) != 1 || reported[0] != err || m.count(MetricHookError) != 1 {
+				t.Errorf("reported %v with %d hook errors counted", reported, m.count(MetricHookError))
+			}
+		})
+	}
+}
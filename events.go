@@ -0,0 +1,92 @@
+package trustedproxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind is the kind of a trust Event.
+type EventKind uint
+
+const (
+	// EventResolved is published when a request is resolved.
+	EventResolved EventKind = iota
+
+	// EventSpoofAttempt is published instead of EventResolved when a request not coming from a trusted proxy
+	// carries forwarding headers.
+	EventSpoofAttempt
+
+	// EventError is published when a request fails with an error.
+	EventError
+)
+
+// Event is a trust decision made by HTTPHandler.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+
+	// Peer is the RemoteAddr of the connection.
+	Peer string
+
+	// Result is the resolved values, empty for EventError.
+	Result Result
+
+	// Fingerprint is the ChainFingerprint of the result, so the logged events can be grouped by proxy path
+	// without the chains, empty for EventError and the requests not coming from a trusted proxy.
+	Fingerprint string
+
+	// ErrorType and Err are the error of EventError.
+	ErrorType ErrorType
+	Err       error
+}
+
+// EventBroker is an in-memory pub/sub of trust events, e.g. for streaming live resolutions into an embedded
+// admin UI. Publishing never blocks, events are dropped for the subscribers whose buffer is full.
+type EventBroker struct {
+	mu   sync.RWMutex
+	subs map[chan Event]struct{}
+
+	dropped uint64
+}
+
+// NewEventBroker returns an EventBroker without subscribers.
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subs: map[chan Event]struct{}{}}
+}
+
+// Subscribe returns a channel receiving the events with the buffer size, and the function to unsubscribe
+// which also closes the channel.
+func (b *EventBroker) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends the event to every subscriber without blocking.
+func (b *EventBroker) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of events dropped because of full buffers.
+func (b *EventBroker) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
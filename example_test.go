@@ -0,0 +1,81 @@
+package trustedproxy_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/eslym/trustedproxy"
+)
+
+func ExampleWithTrustedRequest() {
+	whitelist, _ := trustedproxy.NewCIDRWhitelist("10.0.0.0/8")
+	handler := trustedproxy.WithTrustedRequest(whitelist, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.RemoteAddr)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	// Output: 203.0.113.7
+}
+
+func ExampleFromContext() {
+	whitelist, _ := trustedproxy.NewCIDRWhitelist("10.0.0.0/8")
+	handler := trustedproxy.WithTrustedProxyContext(whitelist, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fr, ok := trustedproxy.FromContext(r.Context())
+		if !ok {
+			return
+		}
+		fmt.Println(fr.GetProxyIP(), fr.GetTrustedRemoteAddr(), fr.GetTrustedProto(), fr.GetTrustedHost())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.internal/", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "www.example.com")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	// Output: 10.0.0.2 203.0.113.7 https www.example.com
+}
+
+func ExampleHTTPHandler_strictParsing() {
+	whitelist, _ := trustedproxy.NewCIDRWhitelist("10.0.0.0/8")
+	handler := &trustedproxy.HTTPHandler{
+		Extractor:     whitelist,
+		StrictParsing: true,
+		ErrorHandler: func(t trustedproxy.ErrorType, err error, w http.ResponseWriter, r *http.Request) {
+			fmt.Println(t == trustedproxy.ErrTypeMalformedHeader, err)
+			w.WriteHeader(http.StatusBadRequest)
+		},
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Println(r.RemoteAddr)
+		}),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, not-an-ip")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	// Output: true malformed forwarded for entry "not-an-ip"
+}
+
+func ExampleForwardedRequest_BuildRequestForForward() {
+	whitelist, _ := trustedproxy.NewCIDRWhitelist("10.0.0.0/8")
+	handler := trustedproxy.WithTrustedProxyContext(whitelist, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fr, _ := trustedproxy.FromContext(r.Context())
+		out := fr.BuildRequestForForward(false)
+		fmt.Println(out.Header.Get("X-Forwarded-For"))
+		fmt.Println(out.Header.Get("X-Forwarded-Proto"), out.Header.Get("X-Forwarded-Port"))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Set("X-Forwarded-For", "192.0.2.66, 203.0.113.7")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	// Output:
+	// 192.0.2.66, 203.0.113.7
+	// https 443
+}
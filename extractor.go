@@ -3,6 +3,7 @@ package trustedproxy
 import (
 	"fmt"
 	"net"
+	"net/http"
 )
 
 // IPExtractor is an interface that extracts the ip address from the ip chain
@@ -11,17 +12,86 @@ type IPExtractor interface {
 	Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error)
 }
 
+// RequestIPExtractor is an IPExtractor which needs more than the ip chain, e.g. the port of the immediate peer
+// or the request headers, HTTPHandler calls ResolveRequest instead of Resolve if the extractor implements it.
+type RequestIPExtractor interface {
+	IPExtractor
+
+	// ResolveRequest returns the proxy ip, trusted remote ip, and the rest of the ip chain
+	ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error)
+}
+
+// IPExtractorFunc is an adapter to allow the use of ordinary functions as IPExtractor.
+type IPExtractorFunc func(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error)
+
+// Resolve calls f(remote, forwarded).
+func (f IPExtractorFunc) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return f(remote, forwarded)
+}
+
 // CIDRWhitelist check the ip from the right to the left, treat the first non-whitelisted ip as the remote ip,
 // the ip before the remote as proxy ip, and the rest of the ip chain as the forwarded ips
 // see https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Forwarded-For#selecting_an_ip_address
 type CIDRWhitelist struct {
 	Whitelist []*net.IPNet
+
+	// Exclude is the networks carved out of the Whitelist, they are checked before the Whitelist,
+	// e.g. trust 10.0.0.0/8 except 10.5.0.0/16.
+	Exclude []*net.IPNet
 }
 
 // OffsetIPExtractor start from the right to the left, treat the first ip as the proxy ip, the second ip as
 // the remote ip, and the rest of the ip chain as the forwarded ips
 type OffsetIPExtractor uint
 
+// TrustDepth trusts exactly the last n hops of the ip chain as proxies, including the immediate peer, and takes
+// the (n+1)th ip from the right as the remote ip, like the "trust proxy" count of Express or Rails. An error is
+// returned if the chain is shorter, TrustDepth(0) trusts nobody.
+type TrustDepth uint
+
+// TolerantTrustDepth works like TrustDepth but takes the leftmost ip as the remote ip when the chain is shorter
+// than expected, instead of failing the request.
+type TolerantTrustDepth uint
+
+// VerifiedOffsetIPExtractor applies the offset only when the immediate peer is in the Peers whitelist,
+// otherwise the request is treated as not coming from a trusted proxy. Pure offset extraction trusts
+// anyone able to connect directly, so this should be preferred whenever the proxy ips are known.
+type VerifiedOffsetIPExtractor struct {
+	Offset OffsetIPExtractor
+	Peers  *CIDRWhitelist
+}
+
+// DepthLimitedWhitelist walks the chain like CIDRWhitelist but trusts at most MaxDepth proxies including the
+// immediate peer, the next ip is the remote ip even if it is whitelisted, so a compromised proxy inside the
+// trusted range cannot walk the resolution further back by appending fake entries. A MaxDepth of 0 trusts nobody.
+type DepthLimitedWhitelist struct {
+	Whitelist *CIDRWhitelist
+	MaxDepth  uint
+}
+
+// SingleHopWhitelist takes exactly the last ip of the chain as the remote ip when the immediate peer is in the
+// Whitelist, even if that ip is also trusted, matching nginx with real_ip_recursive off.
+// see https://nginx.org/en/docs/http/ngx_http_realip_module.html#real_ip_recursive
+type SingleHopWhitelist struct {
+	Whitelist *CIDRWhitelist
+}
+
+// FullChainWhitelist requires every hop between the client and this server to be in the Whitelist, the leftmost
+// ip is the remote ip and an error naming the first untrusted hop is returned otherwise, so a rogue proxy
+// inserted mid-chain is detected instead of being taken as the client. Requests from an untrusted peer are
+// treated as not coming from a trusted proxy.
+type FullChainWhitelist struct {
+	Whitelist *CIDRWhitelist
+}
+
+// TrustAll trusts every hop of the ip chain, treat the leftmost ip as the remote ip and the ip after it as the
+// proxy ip. It is only safe when nobody but the trusted proxies can reach the server, e.g. a fully private network.
+type TrustAll struct{}
+
+// TrustNone ignores the ip chain and treats every request as not coming from a trusted proxy, for servers
+// which are directly exposed to the clients.
+type TrustNone struct{}
+
 func (c *CIDRWhitelist) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
 	var proxy net.IP
 	for len(forwarded) > 0 {
@@ -35,6 +105,11 @@ func (c *CIDRWhitelist) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.
 }
 
 func (c *CIDRWhitelist) Contains(ip net.IP) bool {
+	for _, cidr := range c.Exclude {
+		if cidr.Contains(ip) {
+			return false
+		}
+	}
 	for _, cidr := range c.Whitelist {
 		if cidr.Contains(ip) {
 			return true
@@ -47,7 +122,7 @@ func (o OffsetIPExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, n
 	ips := append([]net.IP{}, forwarded...)
 	ips = append(ips, remote)
 	size := len(ips)
-	if size <= int(o) {
+	if size <= int(o)+1 {
 		return nil, nil, nil, fmt.Errorf("mis-configured proxy chain")
 	}
 	proxy := ips[size-int(o)-1]
@@ -56,6 +131,95 @@ func (o OffsetIPExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, n
 	return proxy, remote, forwarded, nil
 }
 
+func (v *VerifiedOffsetIPExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if v.Peers == nil || !v.Peers.Contains(remote) {
+		return nil, remote, forwarded, nil
+	}
+	return v.Offset.Resolve(remote, forwarded)
+}
+
+func (d *DepthLimitedWhitelist) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if d.Whitelist == nil {
+		return nil, remote, forwarded, nil
+	}
+	var proxy net.IP
+	for depth := uint(0); depth < d.MaxDepth && len(forwarded) > 0; depth++ {
+		if !d.Whitelist.Contains(remote) {
+			break
+		}
+		proxy = remote
+		remote, forwarded = pop(forwarded)
+	}
+	return proxy, remote, forwarded, nil
+}
+
+func (s *SingleHopWhitelist) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if s.Whitelist == nil || !s.Whitelist.Contains(remote) || len(forwarded) == 0 {
+		return nil, remote, forwarded, nil
+	}
+	client, rest := pop(forwarded)
+	return remote, client, rest, nil
+}
+
+func (f *FullChainWhitelist) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if f.Whitelist == nil || !f.Whitelist.Contains(remote) || len(forwarded) == 0 {
+		return nil, remote, forwarded, nil
+	}
+	for i := len(forwarded) - 1; i > 0; i-- {
+		if !f.Whitelist.Contains(forwarded[i]) {
+			return nil, nil, nil, fmt.Errorf("untrusted hop %s at position %d of the chain", forwarded[i], i)
+		}
+	}
+	proxy := remote
+	if len(forwarded) > 1 {
+		proxy = forwarded[1]
+	}
+	return proxy, forwarded[0], nil, nil
+}
+
+func (TrustAll) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if len(forwarded) == 0 {
+		return nil, remote, forwarded, nil
+	}
+	proxy := remote
+	if len(forwarded) > 1 {
+		proxy = forwarded[1]
+	}
+	return proxy, forwarded[0], nil, nil
+}
+
+func (TrustNone) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return nil, remote, forwarded, nil
+}
+
+func (d TrustDepth) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if d == 0 {
+		return nil, remote, forwarded, nil
+	}
+	if len(forwarded) < int(d) {
+		return nil, nil, nil, fmt.Errorf("chain of %d hops is too short for TrustDepth(%d), %d hops are needed", len(forwarded)+1, d, d+1)
+	}
+	return resolveDepth(int(d), remote, forwarded)
+}
+
+func (d TolerantTrustDepth) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if d == 0 || len(forwarded) == 0 {
+		return nil, remote, forwarded, nil
+	}
+	depth := int(d)
+	if len(forwarded) < depth {
+		depth = len(forwarded)
+	}
+	return resolveDepth(depth, remote, forwarded)
+}
+
+// resolveDepth returns the (depth+1)th ip from the right as the remote ip, the chain must be long enough
+func resolveDepth(depth int, remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	ips := append(append([]net.IP{}, forwarded...), remote)
+	i := len(ips) - depth - 1
+	return ips[i+1], ips[i], ips[:i], nil
+}
+
 func pop(s []net.IP) (net.IP, []net.IP) {
 	length := len(s)
 	if length == 0 {
@@ -63,3 +227,11 @@ func pop(s []net.IP) (net.IP, []net.IP) {
 	}
 	return s[length-1], s[:length-1]
 }
+
+// resolveRequest resolves with ResolveRequest if the extractor implements RequestIPExtractor
+func resolveRequest(e IPExtractor, r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if re, ok := e.(RequestIPExtractor); ok {
+		return re.ResolveRequest(r, remote, forwarded)
+	}
+	return e.Resolve(remote.IP, forwarded)
+}
@@ -0,0 +1,35 @@
+package trustedproxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestOffsetIPExtractorShortChain(t *testing.T) {
+	peer := net.ParseIP("10.0.0.2")
+	tests := []struct {
+		name   string
+		offset OffsetIPExtractor
+		chain  []net.IP
+	}{
+		{"no chain", 0, nil},
+		{"chain as long as the offset", 1, parseIPs([]string{"203.0.113.7"})},
+		{"chain shorter than the offset", 3, parseIPs([]string{"203.0.113.7"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if p := recover(); p != nil {
+					t.Fatalf("panicked: %v", p)
+				}
+			}()
+			if _, _, _, err := tt.offset.Resolve(peer, tt.chain); err == nil {
+				t.Error("short chain is resolved, want an error")
+			}
+		})
+	}
+	proxy, remote, rest, err := OffsetIPExtractor(1).Resolve(peer, parseIPs([]string{"192.0.2.66", "203.0.113.7", "198.51.100.20"}))
+	if err != nil || proxy.String() != "198.51.100.20" || remote.String() != "203.0.113.7" || len(rest) != 1 {
+		t.Errorf("resolved %v %v %v %v", proxy, remote, rest, err)
+	}
+}
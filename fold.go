@@ -0,0 +1,89 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// normalizeForwardHeaders merges the non-canonical spellings of the forwarding headers into the canonical
+// key and unfolds the obs-fold line continuations of their values. net/http canonicalizes and unfolds the
+// headers it parses, for both HTTP/1 and the lower-cased HTTP/2 ones, but the headers set through the map
+// directly, e.g. by an adapter of another server, are seen as is and would otherwise escape the parsing
+// and the sanitation. A folded value is an error if rejectFolded is set, and a line break not starting a
+// continuation always is.
+func normalizeForwardHeaders(h http.Header, extra []string, rejectFolded bool) error {
+	var variants []string
+	for name := range h {
+		canonical := http.CanonicalHeaderKey(name)
+		if canonical != name && isForwardHeader(canonical, extra) {
+			variants = append(variants, name)
+		}
+	}
+	// the map order is random, the merged values must not be
+	sort.Strings(variants)
+	for _, name := range variants {
+		canonical := http.CanonicalHeaderKey(name)
+		h[canonical] = append(h[canonical], h[name]...)
+		delete(h, name)
+	}
+	for name, values := range h {
+		if !isForwardHeader(name, extra) {
+			continue
+		}
+		for i, value := range values {
+			if !strings.ContainsAny(value, "\r\n") {
+				continue
+			}
+			unfolded, err := unfoldHeader(value, rejectFolded)
+			if err != nil {
+				return fmt.Errorf("invalid %s header: %w", name, err)
+			}
+			values[i] = unfolded
+		}
+	}
+	return nil
+}
+
+// isForwardHeader returns true if the canonical header name is a forwarding header or one of the extra ones
+func isForwardHeader(name string, extra []string) bool {
+	if strings.HasPrefix(name, "X-Forwarded-") {
+		return true
+	}
+	for _, names := range [][]string{forwardHeaders, extra} {
+		for _, n := range names {
+			if n != "" && http.CanonicalHeaderKey(n) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unfoldHeader replaces each line break followed by spaces or tabs with a single space, as RFC 7230
+// section 3.2.4 allows a recipient to do.
+func unfoldHeader(value string, rejectFolded bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\r' && c != '\n' {
+			b.WriteByte(c)
+			continue
+		}
+		if c == '\r' && i+1 < len(value) && value[i+1] == '\n' {
+			i++
+		}
+		if i+1 >= len(value) || (value[i+1] != ' ' && value[i+1] != '\t') {
+			return "", fmt.Errorf("line break in value")
+		}
+		if rejectFolded {
+			return "", fmt.Errorf("folded value")
+		}
+		for i+1 < len(value) && (value[i+1] == ' ' || value[i+1] == '\t') {
+			i++
+		}
+		b.WriteByte(' ')
+	}
+	return b.String(), nil
+}
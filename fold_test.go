@@ -0,0 +1,128 @@
+package trustedproxy
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeForwardHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       http.Header
+		rejectFolded bool
+		want         []string
+		wantErr      bool
+	}{
+		{"canonical", http.Header{"X-Forwarded-For": {"203.0.113.7"}}, false, []string{"203.0.113.7"}, false},
+		{"http2 lower case", http.Header{"x-forwarded-for": {"203.0.113.7"}}, false, []string{"203.0.113.7"}, false},
+		{"mixed case", http.Header{"X-FORWARDED-FOR": {"203.0.113.7"}}, false, []string{"203.0.113.7"}, false},
+		{"spellings are merged in order", http.Header{
+			"X-Forwarded-For": {"192.0.2.1"},
+			"x-forwarded-for": {"192.0.2.3"},
+			"X-FORWARDED-FOR": {"192.0.2.2"},
+		}, false, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, false},
+		{"obs-fold crlf", http.Header{"X-Forwarded-For": {"192.0.2.1,\r\n 203.0.113.7"}}, false, []string{"192.0.2.1, 203.0.113.7"}, false},
+		{"obs-fold lf and tabs", http.Header{"X-Forwarded-For": {"192.0.2.1,\n\t\t203.0.113.7"}}, false, []string{"192.0.2.1, 203.0.113.7"}, false},
+		{"obs-fold rejected", http.Header{"X-Forwarded-For": {"192.0.2.1,\r\n 203.0.113.7"}}, true, nil, true},
+		{"bare line break", http.Header{"X-Forwarded-For": {"192.0.2.1\r\nX-Forwarded-For: 10.0.0.1"}}, false, nil, true},
+		{"trailing line break", http.Header{"x-forwarded-for": {"192.0.2.1\n"}}, false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := normalizeForwardHeaders(tt.header, nil, tt.rejectFolded)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("normalized to %v, want an error", tt.header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.header["X-Forwarded-For"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("X-Forwarded-For is %q, want %q", got, tt.want)
+			}
+			if len(tt.header) != 1 {
+				t.Errorf("spellings are left in %v", tt.header)
+			}
+		})
+	}
+}
+
+func TestNormalizeForwardHeadersKeepsOthers(t *testing.T) {
+	h := http.Header{"user-agent": {"curl\r\n x"}, "x-custom": {"a"}, "x-request-id": {"1"}}
+	if err := normalizeForwardHeaders(h, []string{"X-Request-Id"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h["user-agent"]; !ok {
+		t.Error("unrelated header is renamed")
+	}
+	if h["user-agent"][0] != "curl\r\n x" {
+		t.Error("unrelated header is unfolded")
+	}
+	if got := h["X-Request-Id"]; len(got) != 1 || got[0] != "1" {
+		t.Errorf("extra header is not merged: %v", h)
+	}
+}
+
+// TestFoldedWireRequest runs requests as they are sent on the wire through the parser of net/http and the
+// handler, the folding is unfolded by net/http before the handler sees it
+func TestFoldedWireRequest(t *testing.T) {
+	whitelist, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		wire   string
+		remote string
+	}{
+		{"obs-fold", "GET / HTTP/1.1\r\nHost: example.com\r\nX-Forwarded-For: 192.0.2.66,\r\n 203.0.113.7\r\n\r\n", "203.0.113.7"},
+		{"lower case name", "GET / HTTP/1.1\r\nHost: example.com\r\nx-forwarded-for: 203.0.113.7\r\n\r\n", "203.0.113.7"},
+		{"repeated lines", "GET / HTTP/1.1\r\nHost: example.com\r\nX-Forwarded-For: 192.0.2.66\r\nx-FORWARDED-for: 203.0.113.7\r\n\r\n", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(tt.wire)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = "10.0.0.2:4711"
+			var got string
+			WithTrustedRequest(whitelist, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.remote {
+				t.Errorf("remote address is %q, want %q", got, tt.remote)
+			}
+		})
+	}
+}
+
+func TestRejectFoldedHandler(t *testing.T) {
+	whitelist, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := false
+	h := &HTTPHandler{
+		Extractor:    whitelist,
+		RejectFolded: true,
+		ErrorHandler: func(et ErrorType, err error, w http.ResponseWriter, r *http.Request) {
+			failed = et == ErrTypeMalformedHeader
+		},
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "10.0.0.2:4711"
+	// set through the map as an adapter of another server would
+	r.Header["x-forwarded-for"] = []string{"192.0.2.66,\r\n 203.0.113.7"}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if !failed {
+		t.Error("folded value is accepted")
+	}
+}
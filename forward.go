@@ -0,0 +1,231 @@
+package trustedproxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+)
+
+// ForwardOptions controls the headers set by BuildRequestForForwardWith.
+type ForwardOptions struct {
+	// StripForwardedIPs keeps only the trusted remote address in the forwarded chain.
+	StripForwardedIPs bool
+
+	// DedupeHops collapses the consecutive identical hops of the forwarded chain into one.
+	DedupeHops bool
+
+	// SelfAddrs is the addresses of this server, they are dropped from the forwarded chain to keep the
+	// chain free of this server's own hops, e.g. when a request loops through the gateway.
+	SelfAddrs []net.IP
+
+	// HostAddrs is the detected addresses of this host, they are dropped like SelfAddrs and identify this
+	// server in the by= parameter when By is empty.
+	HostAddrs *HostAddrs
+
+	// Forwarded emits the RFC 7239 Forwarded header with the for, by, host and proto parameters.
+	Forwarded bool
+
+	// OmitXForwarded skips the X-Forwarded-* headers, e.g. for upstreams which only understand Forwarded.
+	OmitXForwarded bool
+
+	// StripPrefix does not re-emit the trusted prefix in the X-Forwarded-Prefix header.
+	StripPrefix bool
+
+	// PrefixInPath prepends the trusted prefix to the path of the forwarded request, for upstreams which
+	// are mounted under the same prefix as this server.
+	PrefixInPath bool
+
+	// FingerprintHeader is the header to set the chain fingerprint of the request in, omitted if empty
+	// or the request is not coming from a trusted proxy.
+	FingerprintHeader string
+
+	// ResultHeader is the header to pass the Result of the request to a nested gateway in, e.g.
+	// DefaultResultHeader, omitted if empty. Add it to HMACSigner.SignedHeaders to sign it.
+	ResultHeader string
+
+	// BaggageKey is the key of the W3C baggage member to pass the trusted remote address in, e.g.
+	// DefaultBaggageKey, so the distributed traces show the end client down to the deepest backend. A member
+	// of the same key sent by the client is replaced, omitted if empty.
+	BaggageKey string
+
+	// ClientTrace returns the trace of the upstream call for the Result of the request, e.g. to annotate the
+	// spans of the call with the end client, it is attached to the context of the forwarded request with
+	// httptrace.WithClientTrace. No trace is attached if it is nil or returns nil.
+	ClientTrace func(res Result) *httptrace.ClientTrace
+
+	// By is the by= identifier of this server in the Forwarded header, e.g. its ip or an obfuscated
+	// identifier like "_gateway", omitted if empty.
+	By string
+}
+
+func (f *forwardedRequest) BuildRequestForForwardWith(opts ForwardOptions) *http.Request {
+	req := f.Clone(f.Context())
+	req.Host = f.GetTrustedHost()
+
+	// clone the url to avoid modifying the original request url
+	if opts.PrefixInPath {
+		req.URL = f.GetTrustedURL()
+	} else {
+		u := *f.requestURL
+		req.URL = &u
+	}
+
+	// the custom headers are removed as well, the forwarded request always uses the standard ones
+	for _, name := range f.handler.headerConfig().names() {
+		req.Header.Del(name)
+	}
+	req.Header.Del("Forwarded")
+	req.Header.Del("X-Forwarded-For")
+	req.Header.Del("X-Forwarded-Host")
+	req.Header.Del("X-Forwarded-Proto")
+	req.Header.Del("X-Forwarded-Port")
+	req.Header.Del("X-Forwarded-Prefix")
+	req.Header.Del("X-Real-IP")
+	if h := f.handler; h != nil && h.ResultHeader != "" {
+		req.Header.Del(h.ResultHeader)
+	}
+
+	var chain []net.IP
+
+	if !opts.StripForwardedIPs {
+		chain = cleanChain(cloneIPs(f.trustedForwardedFor), opts)
+	}
+
+	// the undisclosed hops are nil, a client's literal 0.0.0.0 is passed on as is
+	if f.unknownRemote {
+		chain = append(chain, nil)
+	} else {
+		chain = append(chain, f.GetTrustedRemoteAddr())
+	}
+
+	if !opts.OmitXForwarded {
+		ips := make([]string, len(chain))
+		for i, ip := range chain {
+			ips[i] = hopNode(ip)
+		}
+		req.Header.Set("X-Forwarded-For", strings.Join(ips, ", "))
+		req.Header.Set("X-Forwarded-Host", f.GetTrustedHost())
+		req.Header.Set("X-Forwarded-Proto", f.GetTrustedProto())
+		req.Header.Set("X-Forwarded-Port", strconv.Itoa(f.GetTrustedPort()))
+		if prefix := f.GetTrustedPrefix(); prefix != "" && !opts.StripPrefix && !opts.PrefixInPath {
+			req.Header.Set("X-Forwarded-Prefix", prefix)
+		}
+	}
+
+	if opts.FingerprintHeader != "" {
+		if fp := f.GetResult().ChainFingerprint(); fp != "" {
+			req.Header.Set(opts.FingerprintHeader, fp)
+		}
+	}
+
+	if opts.ResultHeader != "" {
+		req.Header.Set(opts.ResultHeader, EncodeResult(f.GetResult()))
+	}
+
+	if opts.Forwarded {
+		elements := make([]string, len(chain))
+		for i, ip := range chain {
+			elements[i] = "for=" + quoteForwarded(forwardedNode(ip))
+		}
+		// only the element added by this server has the by, host and proto parameters
+		last := elements[len(elements)-1]
+		if opts.By != "" {
+			last += ";by=" + quoteForwarded(opts.By)
+		} else if ip := opts.HostAddrs.identity(req); ip != nil {
+			last += ";by=" + quoteForwarded(forwardedNode(ip))
+		}
+		last += ";host=" + quoteForwarded(f.GetTrustedHost())
+		last += ";proto=" + f.GetTrustedProto()
+		elements[len(elements)-1] = last
+		req.Header.Set("Forwarded", strings.Join(elements, ", "))
+	}
+
+	if opts.BaggageKey != "" {
+		setBaggage(req.Header, opts.BaggageKey, f.GetTrustedRemoteAddr().String())
+	}
+
+	if opts.ClientTrace != nil {
+		if trace := opts.ClientTrace(f.GetResult()); trace != nil {
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		}
+	}
+
+	if h := f.handler; h != nil && h.ForwardHook != nil {
+		// there is no response to fail here, so the hook errors are never fatal
+		err := h.runHook("ForwardHook", func() error {
+			h.ForwardHook(req)
+			return nil
+		})
+		if err != nil {
+			h.reportHookError(err)
+		}
+	}
+
+	return req
+}
+
+// cleanChain drops the self addresses and the consecutive identical hops of the chain according to the options
+func cleanChain(chain []net.IP, opts ForwardOptions) []net.IP {
+	if !opts.DedupeHops && len(opts.SelfAddrs) == 0 && opts.HostAddrs == nil {
+		return chain
+	}
+	res := chain[:0]
+	for _, ip := range chain {
+		if containsIP(opts.SelfAddrs, ip) || opts.HostAddrs.Contains(ip) {
+			continue
+		}
+		if opts.DedupeHops && len(res) > 0 && res[len(res)-1].Equal(ip) {
+			continue
+		}
+		res = append(res, ip)
+	}
+	return res
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hopNode returns the X-Forwarded-For form of the ip, "unknown" for an undisclosed hop
+func hopNode(ip net.IP) string {
+	if ip == nil {
+		return "unknown"
+	}
+	return ip.String()
+}
+
+// forwardedNode returns the node form of the ip, IPv6 addresses must be enclosed in brackets, "unknown" for an
+// undisclosed hop
+func forwardedNode(ip net.IP) string {
+	if ip == nil {
+		return "unknown"
+	}
+	if ip.To4() == nil && len(ip) == net.IPv6len {
+		return "[" + ip.String() + "]"
+	}
+	return ip.String()
+}
+
+// quoteForwarded returns the value as a token if possible, or as a quoted-string otherwise
+func quoteForwarded(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
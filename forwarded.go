@@ -0,0 +1,171 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HeaderMode selects the header family the ip chain, host and proto are read from.
+type HeaderMode uint
+
+const (
+	// HeaderModeXForwarded reads X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto.
+	HeaderModeXForwarded HeaderMode = iota
+
+	// HeaderModeForwarded reads the RFC 7239 Forwarded header, only use it when every trusted proxy
+	// appends to the Forwarded header, otherwise the client can spoof the whole chain.
+	HeaderModeForwarded
+
+	// HeaderModePreferForwarded reads the Forwarded header when the request has one and the X-Forwarded-*
+	// headers otherwise, for mixed proxy chains. Like HeaderModeForwarded, every trusted proxy must append to
+	// or strip the Forwarded header.
+	HeaderModePreferForwarded
+
+	// HeaderModeCrossCheck reads the headers like HeaderModePreferForwarded, and when both families are present
+	// compares their chains, a mismatch is counted as MetricHeaderMismatch and reported to OnHeaderMismatch.
+	HeaderModeCrossCheck
+)
+
+// ForwardedElement is a forwarded-element of the RFC 7239 Forwarded header, the node values (For and By)
+// are kept as is, e.g. "192.0.2.60", "[2001:db8::1]:4711", "unknown" or "_hidden".
+type ForwardedElement struct {
+	For   string
+	By    string
+	Host  string
+	Proto string
+}
+
+// ParseForwarded parses the values of the Forwarded header into its elements.
+// see https://www.rfc-editor.org/rfc/rfc7239
+func ParseForwarded(values []string) ([]ForwardedElement, error) {
+	var res []ForwardedElement
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			if strings.TrimSpace(element) == "" {
+				continue
+			}
+			var e ForwardedElement
+			for _, pair := range splitQuoted(element, ';') {
+				if strings.TrimSpace(pair) == "" {
+					continue
+				}
+				key, val, err := splitPair(pair)
+				if err != nil {
+					return nil, fmt.Errorf("invalid forwarded header: %w", err)
+				}
+				switch strings.ToLower(strings.TrimSpace(key)) {
+				case "for":
+					e.For = val
+				case "by":
+					e.By = val
+				case "host":
+					e.Host = val
+				case "proto":
+					e.Proto = strings.ToLower(val)
+				}
+			}
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+// ExtractForwardedIPs returns the ip chain from the for= parameters of the Forwarded header,
+// the elements without a for= ip (unknown or obfuscated) are skipped.
+func ExtractForwardedIPs(h *http.Header) []net.IP {
+	return extractForwardedIPs(h, false)
+}
+
+// extractForwardedIPs returns the ip chain of the Forwarded header, the undisclosed hops are kept as nil if
+// keepUnknown is set
+func extractForwardedIPs(h *http.Header, keepUnknown bool) []net.IP {
+	elements, _ := ParseForwarded(h.Values("Forwarded"))
+	var res []net.IP
+	for _, e := range elements {
+		if ip := ParseForwardedNode(e.For); ip != nil {
+			res = append(res, ip)
+		} else if keepUnknown && isObfuscatedNode(e.For) {
+			res = append(res, nil)
+		}
+	}
+	return res
+}
+
+// ParseForwardedNode returns the ip of a node value, e.g. "192.0.2.60:8080" or "[2001:db8::1]:4711",
+// nil is returned for unknown or obfuscated nodes.
+func ParseForwardedNode(node string) net.IP {
+	return parseIPEntry(node)
+}
+
+// validateForwarded returns an error if the Forwarded header is malformed or any element has no for= ip, an
+// undisclosed for= node is accepted if allowUnknown is set
+func validateForwarded(values []string, allowUnknown bool) error {
+	elements, err := ParseForwarded(values)
+	if err != nil {
+		return err
+	}
+	for _, e := range elements {
+		if allowUnknown && isObfuscatedNode(e.For) {
+			continue
+		}
+		if parseStrictIPEntry(e.For, true) == nil {
+			return fmt.Errorf("malformed forwarded for=%q", e.For)
+		}
+	}
+	return nil
+}
+
+// lastHeaderValue returns the rightmost value of the header across its lines and comma separated values,
+// the one added by the nearest proxy like the rightmost element of the Forwarded header is
+func lastHeaderValue(h http.Header, name string) string {
+	if name == "" {
+		return ""
+	}
+	values := h.Values(name)
+	for i := len(values) - 1; i >= 0; i-- {
+		parts := strings.Split(values[i], ",")
+		for j := len(parts) - 1; j >= 0; j-- {
+			if v := strings.TrimSpace(parts[j]); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// headerConflict returns an error if the header has different values across its lines and comma separated
+// values, compared case-insensitively
+func headerConflict(h http.Header, name string) error {
+	if name == "" {
+		return nil
+	}
+	var first string
+	for _, line := range h.Values(name) {
+		for _, part := range strings.Split(line, ",") {
+			v := strings.TrimSpace(part)
+			if v == "" {
+				continue
+			}
+			if first == "" {
+				first = v
+			} else if !strings.EqualFold(first, v) {
+				return fmt.Errorf("conflicting %s values %q and %q", name, first, v)
+			}
+		}
+	}
+	return nil
+}
+
+// lastForwardedValue returns the last non-empty value of the parameter, which is the one appended by the
+// nearest proxy.
+func lastForwardedValue(h http.Header, get func(e ForwardedElement) string) string {
+	elements, _ := ParseForwarded(h.Values("Forwarded"))
+	for i := len(elements) - 1; i >= 0; i-- {
+		if v := get(elements[i]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package trustedproxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseForwarded(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []ForwardedElement
+		wantErr bool
+	}{
+		{"single element", []string{"for=192.0.2.60;proto=http;by=203.0.113.43"},
+			[]ForwardedElement{{For: "192.0.2.60", By: "203.0.113.43", Proto: "http"}}, false},
+		{"elements and lines", []string{"for=192.0.2.43, for=198.51.100.17", "for=10.0.0.2"},
+			[]ForwardedElement{{For: "192.0.2.43"}, {For: "198.51.100.17"}, {For: "10.0.0.2"}}, false},
+		{"quoted ipv6 with port", []string{`for="[2001:db8:cafe::17]:4711"`},
+			[]ForwardedElement{{For: "[2001:db8:cafe::17]:4711"}}, false},
+		{"separators in quotes", []string{`for="_a,b;c", host="example.com"`},
+			[]ForwardedElement{{For: "_a,b;c"}, {Host: "example.com"}}, false},
+		{"escaped quote", []string{`host="ex\"ample.com"`},
+			[]ForwardedElement{{Host: `ex"ample.com`}}, false},
+		{"case-insensitive names", []string{"For=unknown;PROTO=HTTPS;Host=Example.com"},
+			[]ForwardedElement{{For: "unknown", Host: "Example.com", Proto: "https"}}, false},
+		{"empty elements and pairs", []string{" , for=192.0.2.1;;proto=https ,"},
+			[]ForwardedElement{{For: "192.0.2.1", Proto: "https"}}, false},
+		{"unknown parameters", []string{"for=192.0.2.1;secret=x"},
+			[]ForwardedElement{{For: "192.0.2.1"}}, false},
+		{"no header", nil, nil, false},
+		{"pair without value", []string{"for=192.0.2.1;proto"}, nil, true},
+		{"element without pair", []string{"for=192.0.2.1, garbage"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseForwarded(tt.values)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsed %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateForwarded(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       []string
+		allowUnknown bool
+		wantErr      bool
+	}{
+		{"ips", []string{`for=192.0.2.1, for="[2001:db8::1]:4711"`}, false, false},
+		{"unknown rejected", []string{"for=192.0.2.1, for=unknown"}, false, true},
+		{"unknown allowed", []string{"for=192.0.2.1, for=unknown, for=_hidden"}, true, false},
+		{"missing for", []string{"proto=https"}, true, true},
+		{"not an ip", []string{"for=example.com"}, true, true},
+		{"malformed", []string{"for"}, true, true},
+		{"obfuscated port", []string{`for="[2001:db8::1]:_8a2f"`}, false, false},
+		{"port garbage", []string{`for="192.0.2.1:garbage"`}, false, true},
+		{"empty port", []string{`for="192.0.2.1:"`}, false, true},
+		{"port out of range", []string{`for="192.0.2.1:65536"`}, false, true},
+		{"bracket suffix", []string{`for="[2001:db8::1]junk"`}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateForwarded(tt.values, tt.allowUnknown)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateForwardedFor(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       []string
+		allowUnknown bool
+		wantErr      bool
+	}{
+		{"ips", []string{"203.0.113.7, 2001:db8::1", "198.51.100.2"}, false, false},
+		{"ports", []string{"203.0.113.7:4711, [2001:db8::1]:8080, [2001:db8::1], fe80::1%eth0"}, false, false},
+		{"unknown rejected", []string{"203.0.113.7, unknown"}, false, true},
+		{"unknown allowed", []string{"203.0.113.7, unknown, _hidden"}, true, false},
+		{"not an ip", []string{"example.com"}, false, true},
+		{"port garbage", []string{"203.0.113.7:garbage"}, false, true},
+		{"empty port", []string{"203.0.113.7:"}, false, true},
+		{"zero port", []string{"203.0.113.7:0"}, false, true},
+		{"port out of range", []string{"203.0.113.7:65536"}, false, true},
+		{"obfuscated port", []string{"[2001:db8::1]:_8a2f"}, false, true},
+		{"bracket suffix", []string{"[2001:db8::1]junk"}, false, true},
+		{"unclosed bracket", []string{"[2001:db8::1"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateForwardedFor(tt.values, tt.allowUnknown)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
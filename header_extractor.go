@@ -0,0 +1,56 @@
+package trustedproxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedHeaderExtractor trusts a single vendor header carrying the client ip, e.g. CF-Connecting-IP behind
+// Cloudflare, but only when the immediate peer is in Peers. The request is treated as not coming from a
+// trusted proxy if the peer is not trusted or the header is missing or invalid.
+type TrustedHeaderExtractor struct {
+	// Header is the name of the header carrying the client ip.
+	Header string
+
+	// Peers is the whitelist of the proxies allowed to set the header.
+	Peers *CIDRWhitelist
+}
+
+// CloudflareConnectingIP returns a TrustedHeaderExtractor of the CF-Connecting-IP header,
+// peers should be the Cloudflare ip ranges.
+func CloudflareConnectingIP(peers *CIDRWhitelist) *TrustedHeaderExtractor {
+	return &TrustedHeaderExtractor{Header: "CF-Connecting-IP", Peers: peers}
+}
+
+// TrueClientIP returns a TrustedHeaderExtractor of the True-Client-IP header used by Akamai and
+// Cloudflare Enterprise, peers should be the ip ranges of the vendor.
+func TrueClientIP(peers *CIDRWhitelist) *TrustedHeaderExtractor {
+	return &TrustedHeaderExtractor{Header: "True-Client-IP", Peers: peers}
+}
+
+// RealIP returns a TrustedHeaderExtractor of the X-Real-IP header set by nginx, peers should be the
+// nginx servers. The remote address of the connection is used when the peer is not trusted.
+func RealIP(peers *CIDRWhitelist) *TrustedHeaderExtractor {
+	return &TrustedHeaderExtractor{Header: "X-Real-IP", Peers: peers}
+}
+
+// Resolve treats the request as not coming from a trusted proxy since the header is not available.
+func (t *TrustedHeaderExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return nil, remote, forwarded, nil
+}
+
+func (t *TrustedHeaderExtractor) ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if r == nil || t.Peers == nil || !t.Peers.Contains(remote.IP) {
+		return nil, remote.IP, forwarded, nil
+	}
+	client := net.ParseIP(strings.TrimSpace(r.Header.Get(t.Header)))
+	if client == nil {
+		return nil, remote.IP, forwarded, nil
+	}
+	// the proxy usually appends the same ip to the chain, it is not part of the rest of the chain
+	if last, rest := pop(forwarded); last != nil && last.Equal(client) {
+		forwarded = rest
+	}
+	return remote.IP, client, forwarded, nil
+}
@@ -0,0 +1,53 @@
+package trustedproxy
+
+// HeaderConfig is the names of the headers the forwarded information is read from when HeaderMode is
+// HeaderModeXForwarded, an empty name disables reading that information from the headers. Start from
+// DefaultHeaderConfig to override only some of the names.
+type HeaderConfig struct {
+	// ForwardedFor is the header of the ip chain, e.g. X-Forwarded-For or X-Client-IP.
+	ForwardedFor string
+
+	// Host is the header of the original host.
+	Host string
+
+	// Proto is the header of the original protocol.
+	Proto string
+
+	// Port is the header of the original port.
+	Port string
+
+	// Prefix is the header of the path prefix the application is mounted under by the proxy.
+	Prefix string
+
+	// SSL is the headers which signal https with the value "on" when the Proto header has no valid
+	// value, e.g. X-Forwarded-Ssl or the Microsoft Front-End-Https sent by IIS/ARR.
+	SSL []string
+}
+
+// DefaultHeaderConfig returns the config of the de-facto standard X-Forwarded-* headers.
+func DefaultHeaderConfig() HeaderConfig {
+	return HeaderConfig{
+		ForwardedFor: "X-Forwarded-For",
+		Host:         "X-Forwarded-Host",
+		Proto:        "X-Forwarded-Proto",
+		Port:         "X-Forwarded-Port",
+		Prefix:       "X-Forwarded-Prefix",
+		SSL:          []string{"X-Forwarded-Ssl", "Front-End-Https"},
+	}
+}
+
+// names returns the header names of the config
+func (c *HeaderConfig) names() []string {
+	return append([]string{c.ForwardedFor, c.Host, c.Proto, c.Port, c.Prefix}, c.SSL...)
+}
+
+// defaultHeaderConfig is shared by the handlers without Headers, it must never be modified
+var defaultHeaderConfig = DefaultHeaderConfig()
+
+// headerConfig returns the header config of the handler, the default config if it is not set
+func (h *HTTPHandler) headerConfig() *HeaderConfig {
+	if h == nil || h.Headers == nil {
+		return &defaultHeaderConfig
+	}
+	return h.Headers
+}
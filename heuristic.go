@@ -0,0 +1,40 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Heuristic is an anomaly check of the resolved requests, e.g. the same peer source port reused across many
+// clients, to detect NAT traversal abuse behind a partially trusted edge. An anomaly is reported by returning
+// an error, which is handled like the error of a hook.
+type Heuristic interface {
+	Check(r *http.Request, res Result) error
+}
+
+// HeuristicFunc is a function implementing Heuristic.
+type HeuristicFunc func(r *http.Request, res Result) error
+
+func (f HeuristicFunc) Check(r *http.Request, res Result) error {
+	return f(r, res)
+}
+
+// runHeuristics runs the heuristics of the handler, false is returned if the request is failed by one
+func (h *HTTPHandler) runHeuristics(fr *forwardedRequest, w http.ResponseWriter, r *http.Request) bool {
+	if len(h.Heuristics) == 0 {
+		return true
+	}
+	res := fr.GetResult()
+	for i, hc := range h.Heuristics {
+		err := h.runHook(fmt.Sprintf("Heuristics[%d]", i), func() error { return hc.Check(r, res) })
+		if err == nil {
+			continue
+		}
+		if h.HookErrorsFatal {
+			h.handleError(ErrTypeHookError, err, w, r)
+			return false
+		}
+		h.reportHookError(err)
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"log"
+)
+
+// HookError is the error returned by a hook, or recovered from its panic.
+type HookError struct {
+	// Hook is the name of the hook, e.g. "OnResolve".
+	Hook string
+
+	// Err is the error returned or recovered.
+	Err error
+
+	// Panicked is true if the hook panicked.
+	Panicked bool
+}
+
+func (e *HookError) Error() string {
+	if e.Panicked {
+		return fmt.Sprintf("hook %s panicked: %v", e.Hook, e.Err)
+	}
+	return fmt.Sprintf("hook %s failed: %v", e.Hook, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// runHook calls the hook with panic recovery, the returned error is always a *HookError
+func (h *HTTPHandler) runHook(name string, hook func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &HookError{Hook: name, Err: panicError(p), Panicked: true}
+		}
+		if err != nil {
+			h.incCounter(MetricHookError)
+		}
+	}()
+	if err := hook(); err != nil {
+		return &HookError{Hook: name, Err: err}
+	}
+	return nil
+}
+
+// panicError returns the recovered value as an error
+func panicError(p any) error {
+	if err, ok := p.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", p)
+}
+
+// reportHookError reports a non-fatal hook error
+func (h *HTTPHandler) reportHookError(err error) {
+	if h.OnHookError != nil {
+		h.OnHookError(err)
+		return
+	}
+	log.Printf("trustedproxy: %v", err)
+}
@@ -0,0 +1,116 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Hop is an entry of a forwarded chain, an ip with optional port, and/or an obfuscated identifier
+// ("unknown" or "_hidden" in RFC 7239 terms) when the proxy does not disclose the ip.
+//
+// The canonical string form is ip[:port][;obfuscated], IPv6 addresses are enclosed in brackets when a
+// port is present, and a hop without ip is only the obfuscated identifier, e.g.
+//
+//	192.0.2.60
+//	192.0.2.60:4711
+//	2001:db8::1
+//	[2001:db8::1]:4711
+//	192.0.2.60;_gateway
+//	unknown
+type Hop struct {
+	IP         net.IP
+	Port       uint16
+	Obfuscated string
+}
+
+// String returns the canonical form of the hop.
+func (h Hop) String() string {
+	var b strings.Builder
+	if h.IP != nil {
+		if h.Port != 0 {
+			b.WriteString(net.JoinHostPort(h.IP.String(), strconv.Itoa(int(h.Port))))
+		} else {
+			b.WriteString(h.IP.String())
+		}
+		if h.Obfuscated != "" {
+			b.WriteByte(';')
+		}
+	}
+	b.WriteString(h.Obfuscated)
+	return b.String()
+}
+
+// ParseHop parses the canonical form of a hop.
+func ParseHop(s string) (Hop, error) {
+	var h Hop
+	head, obfuscated, hasObfuscated := strings.Cut(s, ";")
+	if hasObfuscated {
+		if obfuscated == "" {
+			return Hop{}, fmt.Errorf("invalid hop %q: empty obfuscated identifier", s)
+		}
+		h.Obfuscated = obfuscated
+	}
+	if ip := net.ParseIP(head); ip != nil {
+		h.IP = ip
+		return h, nil
+	}
+	if host, port, err := net.SplitHostPort(head); err == nil {
+		ip := net.ParseIP(host)
+		p, perr := strconv.ParseUint(port, 10, 16)
+		if ip == nil || perr != nil || p == 0 {
+			return Hop{}, fmt.Errorf("invalid hop %q", s)
+		}
+		h.IP, h.Port = ip, uint16(p)
+		return h, nil
+	}
+	if !hasObfuscated && isObfuscatedNode(head) {
+		h.Obfuscated = head
+		return h, nil
+	}
+	return Hop{}, fmt.Errorf("invalid hop %q", s)
+}
+
+// isObfuscatedNode returns true for the RFC 7239 "unknown" and obfuscated ("_" prefixed) identifiers
+func isObfuscatedNode(s string) bool {
+	if strings.EqualFold(s, "unknown") {
+		return true
+	}
+	if len(s) < 2 || s[0] != '_' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHopEntry parses an entry of a forwarded chain as a Hop, the entries which are not an ip are kept as
+// an opaque obfuscated identifier
+func parseHopEntry(s string) Hop {
+	s = strings.TrimSpace(s)
+	if h, err := ParseHop(s); err == nil {
+		return h
+	}
+	if ip := parseIPEntry(s); ip != nil {
+		return Hop{IP: ip}
+	}
+	return Hop{Obfuscated: s}
+}
+
+// parseRawChain returns every entry of the comma separated chain headers as a Hop
+func parseRawChain(headers []string) []Hop {
+	var res []Hop
+	for _, header := range headers {
+		for _, val := range strings.Split(header, ",") {
+			if strings.TrimSpace(val) == "" {
+				continue
+			}
+			res = append(res, parseHopEntry(val))
+		}
+	}
+	return res
+}
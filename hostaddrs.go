@@ -0,0 +1,120 @@
+package trustedproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HostAddrs is the addresses of the network interfaces of this host, detected instead of being listed in the
+// configuration. It is used by ForwardOptions to strip the own hops and to identify this server in the by=
+// parameter, and as a Heuristic reporting the requests looping through this host.
+type HostAddrs struct {
+	// Interfaces returns the addresses of the interfaces, net.InterfaceAddrs is used if it is nil.
+	Interfaces func() ([]net.Addr, error)
+
+	mu  sync.RWMutex
+	ips []net.IP
+}
+
+// DetectHostAddrs returns the addresses of the network interfaces of this host.
+func DetectHostAddrs() (*HostAddrs, error) {
+	a := &HostAddrs{}
+	if err := a.Refresh(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Refresh detects the addresses again, e.g. after an interface is added, the last known addresses are kept
+// on error.
+func (a *HostAddrs) Refresh() error {
+	interfaces := a.Interfaces
+	if interfaces == nil {
+		interfaces = net.InterfaceAddrs
+	}
+	addrs, err := interfaces()
+	if err != nil {
+		return fmt.Errorf("detect host addresses: %w", err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ips = append(ips, v.IP)
+		case *net.IPAddr:
+			ips = append(ips, v.IP)
+		}
+	}
+	a.mu.Lock()
+	a.ips = ips
+	a.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the addresses every interval until the context is done.
+func (a *HostAddrs) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = a.Refresh()
+		}
+	}
+}
+
+// IPs returns a copy of the addresses.
+func (a *HostAddrs) IPs() []net.IP {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return cloneIPs(a.ips)
+}
+
+// Contains returns true if the ip is an address of this host, it is safe to call on a nil HostAddrs.
+func (a *HostAddrs) Contains(ip net.IP) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return containsIP(a.ips, ip)
+}
+
+// Check reports the request as looping if any hop of the chain is a non-loopback address of this host,
+// loopback hops are common for local sidecars and are not reported.
+func (a *HostAddrs) Check(_ *http.Request, res Result) error {
+	hops := append(append([]net.IP{res.RemoteAddr}, res.ForwardedFor...), res.Proxies...)
+	for _, ip := range hops {
+		if ip != nil && !ip.IsLoopback() && a.Contains(ip) {
+			return fmt.Errorf("request looped through this host at %s", ip)
+		}
+	}
+	return nil
+}
+
+// identity returns the address identifying this host for the request, the local address of the connection
+// if it is one of the addresses, otherwise the first non-loopback address, nil if a is nil
+func (a *HostAddrs) identity(r *http.Request) net.IP {
+	if a == nil {
+		return nil
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcp, ok := addr.(*net.TCPAddr); ok && a.Contains(tcp.IP) {
+			return tcp.IP
+		}
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, ip := range a.ips {
+		if !ip.IsLoopback() {
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package trustedproxy
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultHostCacheSize is the size used by NewHostCache when the given size is not positive.
+const DefaultHostCacheSize = 1024
+
+const (
+	// MetricHostCacheHit is incremented when a host is found in a HostCache.
+	MetricHostCacheHit = "host_cache_hit"
+
+	// MetricHostCacheMiss is incremented when a host is not found in a HostCache.
+	MetricHostCacheMiss = "host_cache_miss"
+
+	// MetricHostCacheEviction is incremented when an entry is evicted from a HostCache.
+	MetricHostCacheEviction = "host_cache_eviction"
+)
+
+// HostCache interns the trusted host strings and their parsed urls keyed by the host and the trusted proto,
+// gateways see the same handful of hosts for most of the requests so the steady state allocations of the
+// repeated values can be skipped, the host is split into its port once per entry instead of on every request
+// since splitting a host without a port allocates an error. The host is cached exactly as resolved, enabling
+// the cache never changes what GetTrustedHost or GetTrustedURL return.
+type HostCache struct {
+	size int
+
+	// Budget bounds the memory of the entries, the cache evicts to stay within it and stops caching when
+	// even an empty cache does not fit. It must be set before the cache is used.
+	Budget *MemoryBudget
+
+	// Metrics receives MetricHostCacheHit, MetricHostCacheMiss and MetricHostCacheEviction, no metrics are
+	// recorded if it is nil. It must be set before the cache is used.
+	Metrics Metrics
+
+	mu      sync.RWMutex
+	entries map[hostKey]*hostEntry
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// HostCacheStats is the statistics of a HostCache.
+type HostCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+type hostKey struct {
+	host  string
+	proto string
+}
+
+// hostEntry is never modified once cached, the url is copied before it is handed out
+type hostEntry struct {
+	host string
+	url  url.URL
+	port hostPort
+}
+
+// NewHostCache returns a HostCache holding at most size entries.
+func NewHostCache(size int) *HostCache {
+	if size <= 0 {
+		size = DefaultHostCacheSize
+	}
+	return &HostCache{
+		size:    size,
+		entries: make(map[hostKey]*hostEntry, size),
+	}
+}
+
+// Intern returns the interned copy of host, it is equal to host.
+func (c *HostCache) Intern(host string, proto string) string {
+	return c.entry(host, proto).host
+}
+
+// URL returns the url with the scheme proto and the host, only the Scheme and Host fields are set. The
+// returned url is a copy and can be modified.
+func (c *HostCache) URL(host string, proto string) *url.URL {
+	u := c.entry(host, proto).url
+	return &u
+}
+
+func (c *HostCache) entry(host string, proto string) *hostEntry {
+	key := hostKey{host, proto}
+	c.mu.RLock()
+	e, ok := c.entries[key]
+	c.mu.RUnlock()
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+		c.incCounter(MetricHostCacheHit)
+		return e
+	}
+	atomic.AddUint64(&c.misses, 1)
+	c.incCounter(MetricHostCacheMiss)
+	e = &hostEntry{host: host, url: url.URL{Scheme: proto, Host: host}, port: splitHostPort(host)}
+	cost := hostEntryCost(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.entries[key]; ok {
+		return cached
+	}
+	if len(c.entries) >= c.size {
+		c.evictOne()
+	}
+	for !c.Budget.Reserve(cost) {
+		if len(c.entries) == 0 {
+			return e
+		}
+		c.evictOne()
+	}
+	c.entries[key] = e
+	return e
+}
+
+// evictOne evicts an arbitrary entry, the hot hosts will be back on the next request
+func (c *HostCache) evictOne() {
+	for k := range c.entries {
+		delete(c.entries, k)
+		c.Budget.Release(hostEntryCost(k))
+		atomic.AddUint64(&c.evictions, 1)
+		c.incCounter(MetricHostCacheEviction)
+		return
+	}
+}
+
+func (c *HostCache) incCounter(name string) {
+	if c.Metrics != nil {
+		c.Metrics.IncCounter(name)
+	}
+}
+
+// hostEntryCost counts the key strings, the entry shares them
+func hostEntryCost(key hostKey) int64 {
+	return int64(len(key.host)+len(key.proto)) + 2*entryOverhead
+}
+
+// Stats returns the statistics of the cache.
+func (c *HostCache) Stats() HostCacheStats {
+	c.mu.RLock()
+	entries := len(c.entries)
+	c.mu.RUnlock()
+	return HostCacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Entries:   entries,
+	}
+}
@@ -0,0 +1,164 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type counterMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+func (m *counterMetrics) IncCounter(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters == nil {
+		m.counters = map[string]int{}
+	}
+	m.counters[name]++
+}
+
+func (m *counterMetrics) count(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+func TestHostCacheKeepsResolvedValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		host  string
+		proto string
+		port  string
+	}{
+		{"plain", "example.com", "https", ""},
+		{"mixed case", "Example.COM", "https", ""},
+		{"default port", "example.com:443", "https", ""},
+		{"default http port", "EXAMPLE.com:80", "http", ""},
+		{"other port", "example.com:8443", "https", ""},
+		{"forwarded port", "www.example.com", "https", "8443"},
+		{"ipv6", "[2001:DB8::1]:443", "https", ""},
+	}
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(h *HTTPHandler, host, proto, port string) (string, string, int) {
+		r := httptest.NewRequest(http.MethodGet, "http://backend.internal/path?q=1", nil)
+		r.RemoteAddr = "10.0.0.2:4711"
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		r.Header.Set("X-Forwarded-Host", host)
+		r.Header.Set("X-Forwarded-Proto", proto)
+		if port != "" {
+			r.Header.Set("X-Forwarded-Port", port)
+		}
+		var gotHost, gotURL string
+		var gotPort int
+		h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f, _ := FromContext(r.Context())
+			gotHost, gotURL, gotPort = f.GetTrustedHost(), f.GetTrustedURL().String(), f.GetTrustedPort()
+		}))
+		return gotHost, gotURL, gotPort
+	}
+	cached := &HTTPHandler{Extractor: cdn, HostCache: NewHostCache(16)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, u, port := resolve(&HTTPHandler{Extractor: cdn}, tt.host, tt.proto, tt.port)
+			for i := 0; i < 2; i++ {
+				cHost, cURL, cPort := resolve(cached, tt.host, tt.proto, tt.port)
+				if cHost != host || cURL != u || cPort != port {
+					t.Errorf("cached %q %q %d, uncached %q %q %d", cHost, cURL, cPort, host, u, port)
+				}
+			}
+		})
+	}
+	if stats := cached.HostCache.Stats(); stats.Hits != uint64(len(tests)) || stats.Misses != uint64(len(tests)) {
+		t.Errorf("stats %+v, want %d hits and misses", stats, len(tests))
+	}
+}
+
+func TestHostCacheURLIsCopied(t *testing.T) {
+	c := NewHostCache(4)
+	u := c.URL("example.com", "https")
+	if u.Scheme != "https" || u.Host != "example.com" {
+		t.Fatalf("url %v", u)
+	}
+	u.Host = "evil.example"
+	if got := c.URL("example.com", "https"); got.Host != "example.com" {
+		t.Errorf("cached url is modified to %v", got)
+	}
+	if got := c.Intern("Example.com", "https"); got != "Example.com" {
+		t.Errorf("interned %q", got)
+	}
+}
+
+func TestHostCacheMetrics(t *testing.T) {
+	m := &counterMetrics{}
+	c := NewHostCache(1)
+	c.Metrics = m
+	c.Intern("a.example", "https")
+	c.Intern("a.example", "https")
+	c.Intern("a.example", "http")
+	c.Intern("b.example", "https")
+	for name, want := range map[string]int{
+		MetricHostCacheHit:      1,
+		MetricHostCacheMiss:     3,
+		MetricHostCacheEviction: 2,
+	} {
+		if got := m.count(name); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 2 || stats.Entries != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestHostCacheBudget(t *testing.T) {
+	c := NewHostCache(16)
+	c.Budget = NewMemoryBudget(hostEntryCost(hostKey{"a.example", "https"}))
+	c.Intern("a.example", "https")
+	c.Intern("b.example", "https")
+	if stats := c.Stats(); stats.Entries != 1 || stats.Evictions != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+	if used, limit := c.Budget.Used(), c.Budget.Limit(); used > limit {
+		t.Errorf("used %d of %d", used, limit)
+	}
+}
+
+func BenchmarkHostCache(b *testing.B) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		b.Fatal(err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _ := FromContext(r.Context())
+		_ = f.GetTrustedURL()
+	})
+	for _, bb := range []struct {
+		name  string
+		cache *HostCache
+	}{
+		{"uncached", nil},
+		{"cached", NewHostCache(16)},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			h := &HTTPHandler{Extractor: cdn, HostCache: bb.cache}
+			r := httptest.NewRequest(http.MethodGet, "http://backend.internal/", nil)
+			r.RemoteAddr = "10.0.0.2:4711"
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.Header.Set("X-Forwarded-Host", "www.example.com")
+			r.Header.Set("X-Forwarded-Proto", "https")
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.SetTrustedProxyContext(w, r, next)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package trustedproxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHostnameRefresh is the refresh interval used by HostnameWhitelist.Run when Interval is not positive.
+const DefaultHostnameRefresh = time.Minute
+
+// HostnameWhitelist trusts the proxies by their DNS names, e.g. the load balancers of a cloud provider whose
+// ips change over time, and walks the chain like CIDRWhitelist. The names are resolved by Refresh, the
+// whitelist trusts nobody until the first refresh, and a name failing to resolve keeps its last known ips so
+// a DNS outage does not drop the trust. Entries which are ip addresses are trusted as is.
+type HostnameWhitelist struct {
+	// Hosts is the names of the trusted proxies, it must not be changed once the whitelist is in use.
+	Hosts []string
+
+	// Interval is how often Run refreshes the names, DefaultHostnameRefresh is used if it is not positive.
+	Interval time.Duration
+
+	// Resolver resolves the names, net.DefaultResolver is used if it is nil.
+	Resolver Resolver
+
+	// OnError is invoked with the name failing to resolve during Run, the error is dropped if it is nil.
+	OnError func(host string, err error)
+
+	mu     sync.RWMutex
+	byHost map[string][]net.IP
+	ips    []net.IP
+}
+
+// NewHostnameWhitelist returns a whitelist of the names, call Refresh or Run before use.
+func NewHostnameWhitelist(hosts ...string) *HostnameWhitelist {
+	return &HostnameWhitelist{Hosts: append([]string{}, hosts...)}
+}
+
+// Refresh resolves every name of the whitelist, the first error is returned after the others are resolved.
+func (w *HostnameWhitelist) Refresh(ctx context.Context) error {
+	return w.refresh(ctx, nil)
+}
+
+// Run refreshes the whitelist immediately and then every Interval until the context is done.
+func (w *HostnameWhitelist) Run(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultHostnameRefresh
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_ = w.refresh(ctx, w.OnError)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// IPs returns a copy of the currently trusted ips.
+func (w *HostnameWhitelist) IPs() []net.IP {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return cloneIPs(w.ips)
+}
+
+func (w *HostnameWhitelist) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	var proxy net.IP
+	for len(forwarded) > 0 {
+		if !w.Contains(remote) {
+			break
+		}
+		proxy = remote
+		remote, forwarded = pop(forwarded)
+	}
+	return proxy, remote, forwarded, nil
+}
+
+func (w *HostnameWhitelist) Contains(ip net.IP) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return containsIP(w.ips, ip)
+}
+
+func (w *HostnameWhitelist) refresh(ctx context.Context, onError func(host string, err error)) error {
+	resolver := resolverOrDefault(w.Resolver)
+	w.mu.RLock()
+	byHost := make(map[string][]net.IP, len(w.Hosts))
+	for host, ips := range w.byHost {
+		byHost[host] = ips
+	}
+	w.mu.RUnlock()
+	var res error
+	for _, host := range w.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			byHost[host] = []net.IP{ip}
+			continue
+		}
+		ips, err := resolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			if onError != nil {
+				onError(host, err)
+			}
+			if res == nil {
+				res = err
+			}
+			continue
+		}
+		byHost[host] = ips
+	}
+	var all []net.IP
+	for _, host := range w.Hosts {
+		all = append(all, byHost[host]...)
+	}
+	w.mu.Lock()
+	w.byHost = byHost
+	w.ips = all
+	w.mu.Unlock()
+	return res
+}
@@ -2,8 +2,11 @@ package trustedproxy
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // HTTPHandler is a middleware that sets the trusted proxy context and alters the request to
@@ -12,11 +15,132 @@ type HTTPHandler struct {
 	// Extractor is the IPExtractor used to determine the trusted proxy ip, remote ip, and forwarded ips.
 	Extractor IPExtractor
 
-	// ErrorHandler is the function used to handle errors.
+	// ErrorHandler is the function used to handle errors, PlainErrorHandler is used if it is nil,
+	// unless the deprecated DefaultErrorHandler is replaced.
 	ErrorHandler ErrorHandler
 
 	// Next is the next http.Handler in the middleware chain.
 	Next http.Handler
+
+	// TrustedEdgeHeaders is the list of headers (e.g. X-Request-Id, traceparent) which are only
+	// accepted from a trusted proxy, they are removed from the request if it is not behind a trusted proxy.
+	TrustedEdgeHeaders []string
+
+	// HostCache is the optional cache used to intern the trusted hosts and their urls.
+	HostCache *HostCache
+
+	// PeerCred is the policy used to verify the process on the other side of a unix socket before
+	// trusting its forwarded headers, the server must use PeerCredConnContext as its ConnContext.
+	// Requests over unix sockets are rejected if it is nil or the peer is not allowed.
+	PeerCred *PeerCredPolicy
+
+	// ForwardHook is invoked on every request produced by BuildRequestForForward, e.g. HMACSigner.Sign.
+	ForwardHook ForwardHook
+
+	// Verifier verifies the signature added by an upstream gateway, it is combined with the ip trust
+	// according to SignatureMode. The signature is not checked if it is nil.
+	Verifier *HMACVerifier
+
+	// SignatureMode is how the signature verification is combined with the ip trust, SignatureRequired by default.
+	SignatureMode SignatureMode
+
+	// TolerantParsing also accepts X-Forwarded-For entries separated by semicolons or spaces only, as sent by
+	// some legacy appliances. MetricTolerantParse is counted every time the tolerance is needed.
+	TolerantParsing bool
+
+	// StrictParsing fails the request with ErrTypeMalformedHeader when the chain header contains an entry
+	// which is not an ip, or an ip followed by anything but a 1-65535 port, instead of skipping the entry and
+	// resolving off a partial chain. TolerantParsing is ignored in strict mode.
+	StrictParsing bool
+
+	// RejectFolded fails the request with ErrTypeMalformedHeader when a forwarding header value is folded over
+	// several lines, instead of unfolding it. net/http unfolds the headers it parses, so this only affects the
+	// requests whose headers are set by other means.
+	RejectFolded bool
+
+	// RejectConflicting fails the request with ErrTypeMalformedHeader when the host, proto or port header of
+	// a trusted proxy has different values across its lines or comma separated values. Every value is kept
+	// in GetOriginalForwardHeaders.
+	RejectConflicting bool
+
+	// RightmostValues reads the X-Forwarded host, proto and port headers from their rightmost value across
+	// the lines and comma separated values, the one added by the nearest proxy like the rightmost element
+	// of the Forwarded header. The first line is read as a whole by default, as http.Header.Get does.
+	RightmostValues bool
+
+	// Headers is the names of the forwarding headers, DefaultHeaderConfig is used if it is nil.
+	Headers *HeaderConfig
+
+	// OnHeaderMismatch is invoked with both chains when they differ in HeaderModeCrossCheck, the chain of
+	// the Forwarded header is used unless the returned error is fatal, see HookErrorsFatal.
+	OnHeaderMismatch func(r *http.Request, forwarded []net.IP, xForwardedFor []net.IP) error
+
+	// ResultHeader is the header carrying the Result of an outer gateway, see ForwardOptions.ResultHeader. The
+	// result is adopted instead of the chain only when ResultTrust trusts the peer, and the header is removed
+	// otherwise.
+	ResultHeader string
+
+	// ResultTrust is the extractor trusting the outer gateways allowed to send the ResultHeader, it must be
+	// limited to those gateways, the Extractor trusting the CDN and load balancer ranges passing the headers
+	// of the clients on is never used for it. No result is adopted if it is nil.
+	ResultTrust IPExtractor
+
+	// Strategy is how the remote address is picked from the chain once the extractor trusts the request, the
+	// result of the extractor is used by default.
+	Strategy Strategy
+
+	// HeaderMode selects the header family the ip chain, host and proto are read from.
+	HeaderMode HeaderMode
+
+	// UnknownHops is how the hops not disclosing their ip ("unknown" or obfuscated) are handled, they are
+	// skipped by default. StrictParsing accepts them unless they are skipped.
+	UnknownHops UnknownHopPolicy
+
+	// ChainSource supplies the ip chain instead of the forwarding headers when it has one for the request,
+	// the host, proto and port are still read from the headers.
+	ChainSource ChainSource
+
+	// NoProtoDowngrade keeps the trusted proto as "https" when the request is received over TLS, even if the
+	// trusted proxy forwards "http", so the Secure cookie logic is not broken by a misconfigured edge. The
+	// http to https upgrade by a trusted proxy is not affected, nor is the ClientProto of GetTLSPosture.
+	NoProtoDowngrade bool
+
+	// SoftFail proceeds with the immediate peer as the remote address when the extractor fails, instead of
+	// failing the request with ErrTypeIPExtractorError. The result is marked as degraded, MetricDegraded is
+	// counted and OnDegraded is invoked, so operators still get alerted.
+	SoftFail bool
+
+	// OnDegraded is invoked with the extractor error when a request is degraded by SoftFail.
+	OnDegraded func(fr ForwardedRequest, err error)
+
+	// OnResolve is invoked after the request is resolved and before the next handler, panics are recovered
+	// and reported as *HookError like the returned errors.
+	OnResolve func(fr ForwardedRequest) error
+
+	// Heuristics is the anomaly checks run on every resolved request before OnResolve, their errors are
+	// handled like the hook errors.
+	Heuristics []Heuristic
+
+	// HookErrorsFatal makes a hook error fail the request with ErrTypeHookError, otherwise the error is
+	// reported to OnHookError and the request continues.
+	HookErrorsFatal bool
+
+	// OnHookError receives the non-fatal hook errors, they are logged with the standard logger if it is nil.
+	OnHookError func(err error)
+
+	// Enricher looks up the extra information of the trusted remote address on the first call to
+	// GetEnrichment, wrap it with a SubnetCache to share the lookups within a client subnet.
+	Enricher Enricher
+
+	// Profile records the time spent in the stages of the handler into Result.Timings, the durations are
+	// also observed by Metrics if it implements DurationMetrics.
+	Profile bool
+
+	// Events receives the trust events of the handler, no events are published if it is nil.
+	Events *EventBroker
+
+	// Metrics receives the counters of the handler, no metrics are recorded if it is nil.
+	Metrics Metrics
 }
 
 func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -27,22 +151,391 @@ func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTPHandler) SetTrustedProxyContext(w http.ResponseWriter, r *http.Request, next http.Handler) {
-	fr := &forwardedRequest{}
+	start := h.startStage()
+	fr := &forwardedRequest{handler: h}
 	r = r.Clone(context.WithValue(r.Context(), CtxKeyForwardedRequest, fr))
 	fr.Request = r
-	ips := ExtractForwardedForIPs(&r.Header)
-	raddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	extra := append(h.headerConfig().names(), h.TrustedEdgeHeaders...)
+	if h.ResultHeader != "" {
+		extra = append(extra, h.ResultHeader)
+	}
+	if err := normalizeForwardHeaders(r.Header, extra, h.RejectFolded); err != nil {
+		h.handleError(ErrTypeMalformedHeader, err, w, r)
+		return
+	}
+	fr.originalHeaders = snapshotForwardHeaders(r.Header, extra)
+	fr.timings.Clone = h.endStage(MetricStageClone, start)
+	start = h.startStage()
+	ips, sourced, errType, err := h.parseChain(r)
 	if err != nil {
-		DefaultErrorHandler(ErrTypeUnknownRemoteAddr, err, w, r)
+		h.handleError(errType, err, w, r)
 		return
 	}
-	proxy, trustedRemote, restIps, err := h.Extractor.Resolve(raddr.IP, ips)
+	if sourced {
+		fr.sourcedChain = append([]net.IP{}, ips...)
+	}
+	fr.timings.Parse = h.endStage(MetricStageParse, start)
+	start = h.startStage()
+	trust, errType, err := h.resolveTrust(r, ips)
 	if err != nil {
-		DefaultErrorHandler(ErrTypeIPExtractorError, err, w, r)
+		h.handleError(errType, err, w, r)
 		return
 	}
+	proxy, degraded := trust.proxy, trust.degraded
 	fr.proxyIP = proxy
-	fr.trustedRemoteAddr = trustedRemote
-	fr.trustedForwardedFor = restIps
+	fr.trustedRemoteAddr = trust.remote
+	fr.trustedForwardedFor = trust.rest
+	fr.unknownRemote = trust.unknownRemote
+	fr.peerPort = peerPort(r)
+	fr.degraded = degraded != nil
+	if proxy != nil {
+		fr.trustedProxies = trust.proxies
+		if value := r.Header.Get(h.ResultHeader); h.ResultHeader != "" && value != "" && h.trustsResult(r, ips) {
+			res, err := DecodeResult(value)
+			if err != nil {
+				h.handleError(ErrTypeMalformedHeader, err, w, r)
+				return
+			}
+			fr.adoptResult(res, h.peerIP(r))
+		} else if h.ResultHeader != "" {
+			r.Header.Del(h.ResultHeader)
+		}
+	} else {
+		for _, name := range h.TrustedEdgeHeaders {
+			r.Header.Del(name)
+		}
+		if h.ResultHeader != "" {
+			r.Header.Del(h.ResultHeader)
+		}
+	}
+	fr.init()
+	fr.timings.Resolve = h.endStage(MetricStageResolve, start)
+	if !h.afterResolve(fr, degraded, w, r) {
+		return
+	}
 	next.ServeHTTP(w, r)
 }
+
+// parseChain returns the ip chain of the request supplied by the ChainSource or read from the headers, true
+// if it is supplied by the ChainSource
+func (h *HTTPHandler) parseChain(r *http.Request) ([]net.IP, bool, ErrorType, error) {
+	ips, sourced, err := h.sourceChain(r)
+	if err != nil {
+		return nil, false, ErrTypeChainSourceError, err
+	}
+	if sourced {
+		return ips, true, 0, nil
+	}
+	if ips, err = h.extractIPs(r); err != nil {
+		return nil, false, ErrTypeMalformedHeader, err
+	}
+	if err = h.crossCheck(r, ips); err != nil {
+		return nil, false, ErrTypeHookError, err
+	}
+	return ips, false, 0, nil
+}
+
+// resolution is the outcome of the trust resolution of a chain
+type resolution struct {
+	proxy  net.IP
+	remote net.IP
+	rest   []net.IP
+
+	// unknownRemote is true if the remote is the undisclosed hop the walk stopped at
+	unknownRemote bool
+
+	// proxies is the proxy path ending with the peer, nil if the request is not coming from a trusted proxy
+	proxies []net.IP
+
+	// degraded is the extractor error SoftFail fell back to the peer for
+	degraded error
+}
+
+// resolveTrust resolves the chain with the Extractor, SoftFail and Strategy, and checks the conflicting
+// values of the trusted proxy, shared by the requests and the datagram flows
+func (h *HTTPHandler) resolveTrust(r *http.Request, ips []net.IP) (resolution, ErrorType, error) {
+	var res resolution
+	var errType ErrorType
+	var err error
+	walked := withUnknownHopIP(ips)
+	res.proxy, res.remote, res.rest, errType, err = h.resolve(r, walked)
+	if err != nil && errType == ErrTypeIPExtractorError && h.SoftFail {
+		// attribution problems should not fail the user traffic, fall back to the direct peer
+		res = resolution{remote: h.peerIP(r), degraded: err}
+		err = nil
+		h.incCounter(MetricDegraded)
+	}
+	if err != nil {
+		return resolution{}, errType, err
+	}
+	if res.proxy != nil && h.Strategy == StrategyLeftmostPublic {
+		if p, remote, rest, ok := leftmostPublic(walked, h.peerIP(r)); ok {
+			res.proxy, res.remote, res.rest = p, remote, rest
+		}
+	}
+	stopAtUnknownHop(&res, ips, h.peerIP(r))
+	if res.proxy != nil {
+		res.proxies = proxyPath(append(append([]net.IP{}, ips...), h.peerIP(r)), res.rest)
+		if err := h.checkConflicts(r); err != nil {
+			return resolution{}, ErrTypeMalformedHeader, err
+		}
+	}
+	return res, 0, nil
+}
+
+// afterResolve publishes the event and runs the hooks of the resolved request,
+// false is returned if the request is failed by a hook
+func (h *HTTPHandler) afterResolve(fr *forwardedRequest, degraded error, w http.ResponseWriter, r *http.Request) bool {
+	if h.Events != nil {
+		kind := EventResolved
+		if fr.proxyIP == nil && degraded == nil && len(fr.originalHeaders) > 0 {
+			kind = EventSpoofAttempt
+		}
+		res := fr.GetResult()
+		h.Events.Publish(Event{Kind: kind, Time: time.Now(), Peer: r.RemoteAddr, Result: res, Fingerprint: res.ChainFingerprint(), Err: degraded})
+	}
+	if degraded != nil && h.OnDegraded != nil {
+		err := h.runHook("OnDegraded", func() error {
+			h.OnDegraded(fr, degraded)
+			return nil
+		})
+		if err != nil {
+			h.reportHookError(err)
+		}
+	}
+	if !h.runHeuristics(fr, w, r) {
+		return false
+	}
+	if h.OnResolve != nil {
+		if err := h.runHook("OnResolve", func() error { return h.OnResolve(fr) }); err != nil {
+			if h.HookErrorsFatal {
+				h.handleError(ErrTypeHookError, err, w, r)
+				return false
+			}
+			h.reportHookError(err)
+		}
+	}
+	return true
+}
+
+// trustsResult returns true if ResultTrust trusts the peer of the request to send the ResultHeader
+func (h *HTTPHandler) trustsResult(r *http.Request, ips []net.IP) bool {
+	if h.ResultTrust == nil {
+		return false
+	}
+	raddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	proxy, _, _, err := resolveRequest(h.ResultTrust, r, raddr, cloneIPs(withUnknownHopIP(ips)))
+	return err == nil && proxy != nil
+}
+
+// peerPort returns the source port of the immediate peer, 0 if unknown
+func peerPort(r *http.Request) int {
+	_, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return 0
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0
+	}
+	return int(p)
+}
+
+// peerIP returns the ip of the immediate peer
+func (h *HTTPHandler) peerIP(r *http.Request) net.IP {
+	if _, ok := r.Context().Value(CtxKeyPeerCred).(PeerCred); ok {
+		return UnixSocketProxyIP
+	}
+	raddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return raddr.IP
+}
+
+func (h *HTTPHandler) handleError(t ErrorType, err error, w http.ResponseWriter, r *http.Request) {
+	if h.Events != nil {
+		h.Events.Publish(Event{Kind: EventError, Time: time.Now(), Peer: r.RemoteAddr, ErrorType: t, Err: err})
+	}
+	if h.ErrorHandler != nil {
+		h.ErrorHandler(t, err, w, r)
+		return
+	}
+	fallbackErrorHandler()(t, err, w, r)
+}
+
+// sourceChain returns the chain supplied by the ChainSource, false if there is none
+func (h *HTTPHandler) sourceChain(r *http.Request) ([]net.IP, bool, error) {
+	if h.ChainSource == nil {
+		return nil, false, nil
+	}
+	return h.ChainSource.Chain(r)
+}
+
+// checkConflicts returns an error if RejectConflicting is set and the X-Forwarded host, proto or port header
+// has conflicting values
+func (h *HTTPHandler) checkConflicts(r *http.Request) error {
+	if !h.RejectConflicting || h.headerFamily(r) != HeaderModeXForwarded {
+		return nil
+	}
+	headers := h.headerConfig()
+	for _, name := range []string{headers.Host, headers.Proto, headers.Port} {
+		if err := headerConflict(r.Header, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// headerFamily returns the header family the request is read from, either HeaderModeXForwarded or
+// HeaderModeForwarded, it is safe to call on a nil handler
+func (h *HTTPHandler) headerFamily(r *http.Request) HeaderMode {
+	if h == nil {
+		return HeaderModeXForwarded
+	}
+	switch h.HeaderMode {
+	case HeaderModeForwarded:
+		return HeaderModeForwarded
+	case HeaderModePreferForwarded, HeaderModeCrossCheck:
+		if len(r.Header.Values("Forwarded")) > 0 {
+			return HeaderModeForwarded
+		}
+	}
+	return HeaderModeXForwarded
+}
+
+func (h *HTTPHandler) extractIPs(r *http.Request) ([]net.IP, error) {
+	return h.extractFamily(r, h.headerFamily(r))
+}
+
+// crossCheck compares the chain read from the Forwarded header with the X-Forwarded-For one in
+// HeaderModeCrossCheck, the error of OnHeaderMismatch is returned if it is fatal
+func (h *HTTPHandler) crossCheck(r *http.Request, ips []net.IP) error {
+	if h.HeaderMode != HeaderModeCrossCheck || h.headerFamily(r) != HeaderModeForwarded {
+		return nil
+	}
+	if len(r.Header.Values(h.headerConfig().ForwardedFor)) == 0 {
+		return nil
+	}
+	// a malformed X-Forwarded-For in strict mode is a mismatch as well
+	xff, _ := h.extractFamily(r, HeaderModeXForwarded)
+	if equalIPs(ips, xff) {
+		return nil
+	}
+	h.incCounter(MetricHeaderMismatch)
+	if h.OnHeaderMismatch == nil {
+		return nil
+	}
+	err := h.runHook("OnHeaderMismatch", func() error { return h.OnHeaderMismatch(r, cloneIPs(withUnknownHopIP(ips)), withUnknownHopIP(xff)) })
+	if err == nil {
+		return nil
+	}
+	if h.HookErrorsFatal {
+		return err
+	}
+	h.reportHookError(err)
+	return nil
+}
+
+// extractFamily returns the ip chain of the header family
+func (h *HTTPHandler) extractFamily(r *http.Request, family HeaderMode) ([]net.IP, error) {
+	if family != HeaderModeForwarded {
+		return h.ParseChain(r.Header.Values(h.headerConfig().ForwardedFor))
+	}
+	keepUnknown := h.UnknownHops != UnknownHopSkip
+	if h.StrictParsing {
+		if err := validateForwarded(r.Header.Values("Forwarded"), keepUnknown); err != nil {
+			return nil, err
+		}
+	}
+	ips := extractForwardedIPs(&r.Header, keepUnknown)
+	if keepUnknown {
+		ips = applyUnknownHopPolicy(ips, h.UnknownHops)
+	}
+	return ips, nil
+}
+
+// ParseChain parses the values of an X-Forwarded-For like header with the StrictParsing, TolerantParsing and
+// UnknownHops settings of the handler, so a ChainSource reading other headers parses them like the handler. The
+// undisclosed hops kept by UnknownHopBoundary are nil.
+func (h *HTTPHandler) ParseChain(values []string) ([]net.IP, error) {
+	keepUnknown := h.UnknownHops != UnknownHopSkip
+	var ips []net.IP
+	if h.StrictParsing {
+		if err := validateForwardedFor(values, keepUnknown); err != nil {
+			return nil, err
+		}
+		ips, _ = parseForwardedFor(values, false, keepUnknown)
+	} else {
+		var tolerated bool
+		ips, tolerated = parseForwardedFor(values, h.TolerantParsing, keepUnknown)
+		if tolerated {
+			h.incCounter(MetricTolerantParse)
+		}
+	}
+	if keepUnknown {
+		ips = applyUnknownHopPolicy(ips, h.UnknownHops)
+	}
+	return ips, nil
+}
+
+func (h *HTTPHandler) resolve(r *http.Request, ips []net.IP) (net.IP, net.IP, []net.IP, ErrorType, error) {
+	if cred, ok := r.Context().Value(CtxKeyPeerCred).(PeerCred); ok {
+		return h.resolvePeerCred(r, cred, ips)
+	}
+	raddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return nil, nil, nil, ErrTypeUnknownRemoteAddr, err
+	}
+	if h.Verifier != nil {
+		verified := h.Verifier.Verify(r) == nil
+		if verified && h.SignatureMode == SignatureSufficient {
+			return h.resolveTrustedPeer(r, raddr.IP, ips)
+		}
+		if !verified && h.SignatureMode == SignatureRequired {
+			return nil, raddr.IP, ips, 0, nil
+		}
+	}
+	proxy, remote, rest, err := resolveRequest(h.Extractor, r, raddr, ips)
+	if err != nil {
+		return nil, nil, nil, ErrTypeIPExtractorError, err
+	}
+	if remote, err = attributeRemote(proxy, remote, raddr.IP); err != nil {
+		return nil, nil, nil, ErrTypeIPExtractorError, err
+	}
+	return proxy, remote, rest, 0, nil
+}
+
+func (h *HTTPHandler) resolvePeerCred(r *http.Request, cred PeerCred, ips []net.IP) (net.IP, net.IP, []net.IP, ErrorType, error) {
+	if h.PeerCred == nil || !h.PeerCred.Allow(cred) {
+		err := fmt.Errorf("untrusted unix socket peer pid=%d uid=%d gid=%d", cred.PID, cred.UID, cred.GID)
+		return nil, nil, nil, ErrTypeUnknownRemoteAddr, err
+	}
+	return h.resolveTrustedPeer(r, UnixSocketProxyIP, ips)
+}
+
+// resolveTrustedPeer resolves the chain of a peer trusted by other means than its ip, the peer is the
+// trusted proxy and the rest of the chain is resolved as if the last forwarded ip is the remote address
+func (h *HTTPHandler) resolveTrustedPeer(r *http.Request, peer net.IP, ips []net.IP) (net.IP, net.IP, []net.IP, ErrorType, error) {
+	last, rest := pop(ips)
+	if last == nil {
+		return nil, peer, nil, 0, nil
+	}
+	proxy, remote, rest, err := resolveRequest(h.Extractor, r, &net.TCPAddr{IP: last}, rest)
+	if err != nil {
+		return nil, nil, nil, ErrTypeIPExtractorError, err
+	}
+	if proxy == nil {
+		proxy = peer
+	}
+	return proxy, remote, rest, 0, nil
+}
+
+func (h *HTTPHandler) incCounter(name string) {
+	if h.Metrics != nil {
+		h.Metrics.IncCounter(name)
+	}
+}
@@ -0,0 +1,166 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestWithTrustedRequestMultiHop(t *testing.T) {
+	whitelist, err := NewCIDRWhitelist("10.0.0.0/8", "198.51.100.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		peer   string
+		xff    []string
+		remote string
+	}{
+		{"direct client", "203.0.113.7:4711", nil, "203.0.113.7"},
+		{"untrusted peer with spoofed chain", "203.0.113.7:4711", []string{"10.0.0.9"}, "203.0.113.7"},
+		{"load balancer", "10.0.0.2:4711", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"cdn then load balancer", "10.0.0.2:4711", []string{"203.0.113.7, 198.51.100.20"}, "203.0.113.7"},
+		{"client supplied entries", "10.0.0.2:4711", []string{"192.0.2.66, 203.0.113.7, 198.51.100.20"}, "203.0.113.7"},
+		{"chain over header lines", "10.0.0.2:4711", []string{"192.0.2.66", "203.0.113.7", "198.51.100.20"}, "203.0.113.7"},
+		{"every hop trusted", "10.0.0.2:4711", []string{"10.0.0.5, 198.51.100.20"}, "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := WithTrustedRequest(whitelist, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.peer
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.remote {
+				t.Errorf("remote address is %q, want %q", got, tt.remote)
+			}
+		})
+	}
+}
+
+// forwardTo returns a handler forwarding the requests to the server with BuildRequestForForward
+func forwardTo(t *testing.T, target string) http.Handler {
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fr, ok := FromContext(r.Context())
+		if !ok {
+			t.Error("no forwarded request in the context")
+			return
+		}
+		out := fr.BuildRequestForForward(false)
+		out.RequestURI = ""
+		out.URL.Scheme, out.URL.Host = u.Scheme, u.Host
+		res, err := http.DefaultClient.Do(out)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+		w.WriteHeader(res.StatusCode)
+	})
+}
+
+func TestWithTrustedRequestThroughGateways(t *testing.T) {
+	var got Result
+	app := httptest.NewServer(WithTrustedProxyContext(Loopback(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ResultFromContext(r.Context())
+	})))
+	defer app.Close()
+	inner := httptest.NewServer(WithTrustedProxyContext(Loopback(), forwardTo(t, app.URL)))
+	defer inner.Close()
+
+	whitelist, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edge := WithTrustedProxyContext(whitelist, forwardTo(t, inner.URL))
+	r := httptest.NewRequest(http.MethodGet, "http://www.example.com/path", nil)
+	r.RemoteAddr = "10.0.0.2:4711"
+	r.Header.Set("X-Forwarded-For", "192.0.2.66, 203.0.113.7")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	edge.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status is %d", w.Code)
+	}
+
+	if got.RemoteAddr.String() != "203.0.113.7" {
+		t.Errorf("remote address is %v, want 203.0.113.7", got.RemoteAddr)
+	}
+	if len(got.ForwardedFor) != 1 || got.ForwardedFor[0].String() != "192.0.2.66" {
+		t.Errorf("forwarded for is %v, want [192.0.2.66]", got.ForwardedFor)
+	}
+	if got.Host != "www.example.com" || got.Proto != "https" || got.Port != 443 {
+		t.Errorf("host, proto and port are %q %q %d", got.Host, got.Proto, got.Port)
+	}
+	// the edge forwards the trusted chain only, the app sees the inner gateway as the proxy
+	if len(got.Proxies) != 1 || !got.Proxies[0].IsLoopback() {
+		t.Errorf("proxies are %v, want the inner gateway", got.Proxies)
+	}
+}
+
+func TestWithTrustedRequestSpoofedThroughGateway(t *testing.T) {
+	var got Result
+	app := httptest.NewServer(WithTrustedProxyContext(Loopback(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ResultFromContext(r.Context())
+	})))
+	defer app.Close()
+
+	// the edge trusts nobody, the chain sent by the client must not reach the app
+	edge := WithTrustedProxyContext(TrustNone{}, forwardTo(t, app.URL))
+	r := httptest.NewRequest(http.MethodGet, "http://www.example.com/", nil)
+	r.RemoteAddr = "203.0.113.7:4711"
+	r.Header.Set("X-Forwarded-For", "10.0.0.1")
+	r.Header.Set("X-Forwarded-Host", "admin.internal")
+	edge.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got.RemoteAddr.String() != "203.0.113.7" {
+		t.Errorf("remote address is %v, want 203.0.113.7", got.RemoteAddr)
+	}
+	if got.Host != "www.example.com" {
+		t.Errorf("host is %q, want www.example.com", got.Host)
+	}
+}
+
+// the middleware constructors keep their signatures so they can be stored as plain functions
+var (
+	_ func(IPExtractor, http.Handler) http.Handler = WithTrustedRequest
+	_ func(IPExtractor, http.Handler) http.Handler = WithTrustedProxyContext
+)
+
+func TestMiddlewareWithOptions(t *testing.T) {
+	constructors := []struct {
+		name string
+		new  func(IPExtractor, http.Handler, ...HandlerOption) http.Handler
+	}{
+		{"WithTrustedRequestWith", WithTrustedRequestWith},
+		{"WithTrustedProxyContextWith", WithTrustedProxyContextWith},
+	}
+	for _, c := range constructors {
+		t.Run(c.name, func(t *testing.T) {
+			var errType ErrorType
+			called := false
+			handler := c.new(TrustDepth(1), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}), WithErrorHandler(func(et ErrorType, err error, w http.ResponseWriter, r *http.Request) {
+				errType = et
+			}))
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = "10.0.0.2:4711"
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if called || errType != ErrTypeIPExtractorError {
+				t.Errorf("called %v with error type %v, want ErrTypeIPExtractorError from the option", called, errType)
+			}
+		})
+	}
+}
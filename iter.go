@@ -0,0 +1,43 @@
+//go:build go1.23
+
+package trustedproxy
+
+import (
+	"iter"
+	"net"
+)
+
+// All returns an iterator over the index and ip of the chain, from the furthest hop to the nearest one.
+func (c Chain) All() iter.Seq2[int, net.IP] {
+	return func(yield func(int, net.IP) bool) {
+		for i, ip := range c {
+			if !yield(i, ip) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the index and ip of the chain, from the nearest hop to the furthest one,
+// which is the order the trust is walked in.
+func (c Chain) Backward() iter.Seq2[int, net.IP] {
+	return func(yield func(int, net.IP) bool) {
+		for i := len(c) - 1; i >= 0; i-- {
+			if !yield(i, c[i]) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the networks of the whitelist without copying them like Prefixes does,
+// the networks must not be modified.
+func (c *CIDRWhitelist) All() iter.Seq[*net.IPNet] {
+	return func(yield func(*net.IPNet) bool) {
+		for _, n := range c.Whitelist {
+			if !yield(n) {
+				return
+			}
+		}
+	}
+}
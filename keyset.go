@@ -0,0 +1,89 @@
+package trustedproxy
+
+import (
+	"sync"
+	"time"
+)
+
+// SigningKey is a shared secret with its validity window, a zero time means unbounded.
+type SigningKey struct {
+	ID        string
+	Secret    []byte
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// KeySet is a set of shared secrets supporting rotation without a cutover window: signers use the active key
+// with the latest NotBefore while verifiers accept every active key. A new key is rolled out to the fleet with
+// a NotBefore in the future, so every verifier knows it before any signer starts using it, and the old key is
+// retired by its NotAfter.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []SigningKey
+}
+
+// NewKeySet returns a KeySet with the keys.
+func NewKeySet(keys ...SigningKey) *KeySet {
+	k := &KeySet{}
+	k.Set(keys...)
+	return k
+}
+
+// Set replaces the keys of the set, it is safe to be called while the set is in use.
+func (k *KeySet) Set(keys ...SigningKey) {
+	keys = append([]SigningKey{}, keys...)
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+}
+
+// Signing returns the key to sign with at the time.
+func (k *KeySet) Signing(now time.Time) (SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var res SigningKey
+	found := false
+	for _, key := range k.keys {
+		if !key.activeAt(now) {
+			continue
+		}
+		if !found || key.NotBefore.After(res.NotBefore) {
+			res = key
+			found = true
+		}
+	}
+	return res, found
+}
+
+// Lookup returns the secret of the key if it is active at the time.
+func (k *KeySet) Lookup(id string, now time.Time) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.ID == id && key.activeAt(now) {
+			return key.Secret, true
+		}
+	}
+	return nil, false
+}
+
+// IDs returns the ids of the keys in the set.
+func (k *KeySet) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	res := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		res[i] = key.ID
+	}
+	return res
+}
+
+func (k SigningKey) activeAt(now time.Time) bool {
+	if !k.NotBefore.IsZero() && now.Before(k.NotBefore) {
+		return false
+	}
+	if !k.NotAfter.IsZero() && !now.Before(k.NotAfter) {
+		return false
+	}
+	return true
+}
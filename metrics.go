@@ -0,0 +1,21 @@
+package trustedproxy
+
+const (
+	// MetricTolerantParse is incremented when the tolerant parsing was needed to parse the ip chain.
+	MetricTolerantParse = "tolerant_parse"
+
+	// MetricDegraded is incremented when a request is degraded by SoftFail.
+	MetricDegraded = "degraded"
+
+	// MetricHookError is incremented when a hook returns an error or panics.
+	MetricHookError = "hook_error"
+
+	// MetricHeaderMismatch is incremented when the Forwarded and X-Forwarded-For chains differ in HeaderModeCrossCheck.
+	MetricHeaderMismatch = "header_mismatch"
+)
+
+// Metrics receives the counters of HTTPHandler, implementations must be safe for concurrent use.
+type Metrics interface {
+	// IncCounter increments the counter with the name by one.
+	IncCounter(name string)
+}
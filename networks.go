@@ -0,0 +1,27 @@
+package trustedproxy
+
+// PrivateNetworks returns a whitelist of the private networks, 10.0.0.0/8, 172.16.0.0/12 and 192.168.0.0/16
+// from RFC 1918 and the IPv6 unique local addresses fc00::/7 from RFC 4193.
+func PrivateNetworks() *CIDRWhitelist {
+	return mustCIDRWhitelist("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+}
+
+// Loopback returns a whitelist of the loopback addresses, 127.0.0.0/8 and ::1.
+func Loopback() *CIDRWhitelist {
+	return mustCIDRWhitelist("127.0.0.0/8", "::1/128")
+}
+
+// LinkLocal returns a whitelist of the link-local addresses, 169.254.0.0/16 and fe80::/10.
+func LinkLocal() *CIDRWhitelist {
+	return mustCIDRWhitelist("169.254.0.0/16", "fe80::/10")
+}
+
+// mustCIDRWhitelist returns a whitelist of the networks, it panics on an invalid network so it is only
+// meant for the built-in lists
+func mustCIDRWhitelist(cidrs ...string) *CIDRWhitelist {
+	res, err := NewCIDRWhitelist(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
@@ -0,0 +1,76 @@
+package trustedproxy
+
+import (
+	"context"
+	"net"
+)
+
+var (
+	// CtxKeyPeerCred is the context key for the PeerCred of a unix socket connection.
+	CtxKeyPeerCred = &contextKey{"peer-cred"}
+
+	// UnixSocketProxyIP is the proxy ip reported for requests forwarded by a verified unix socket peer.
+	UnixSocketProxyIP = net.IPv4(127, 0, 0, 1)
+)
+
+// PeerCred is the credentials of the process on the other side of a unix socket.
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerCredPolicy is the allowlist of unix socket peers whose forwarded headers are trusted,
+// an empty list allows any value for that field.
+type PeerCredPolicy struct {
+	PIDs []int32
+	UIDs []uint32
+	GIDs []uint32
+}
+
+// Allow returns true if the credentials are allowed by the policy.
+func (p *PeerCredPolicy) Allow(cred PeerCred) bool {
+	if len(p.PIDs) > 0 && !containsInt32(p.PIDs, cred.PID) {
+		return false
+	}
+	if len(p.UIDs) > 0 && !containsUint32(p.UIDs, cred.UID) {
+		return false
+	}
+	if len(p.GIDs) > 0 && !containsUint32(p.GIDs, cred.GID) {
+		return false
+	}
+	return true
+}
+
+// PeerCredConnContext stores the PeerCred of unix socket connections in the context,
+// it is meant to be used as http.Server.ConnContext and is a no-op for other connections
+// or on platforms without SO_PEERCRED.
+func PeerCredConnContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	cred, err := getPeerCred(uc)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, CtxKeyPeerCred, cred)
+}
+
+func containsInt32(s []int32, v int32) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint32(s []uint32, v uint32) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
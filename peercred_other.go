@@ -0,0 +1,12 @@
+//go:build !linux
+
+package trustedproxy
+
+import (
+	"errors"
+	"net"
+)
+
+func getPeerCred(_ *net.UnixConn) (PeerCred, error) {
+	return PeerCred{}, errors.New("SO_PEERCRED is not supported on this platform")
+}
@@ -0,0 +1,442 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Policy is a trust rule compiled from a small expression language, so intricate rules can live in the
+// configuration instead of code, e.g.
+//
+//	peer in cloudflare && hops <= 3 && proto == https
+//
+// The variables are:
+//
+//	peer    ip      the immediate peer
+//	remote  ip      the trusted remote address, only known when checked as a Heuristic
+//	hops    int     the number of ips in the whole chain including the peer
+//	proto   string  the trusted proto when checked as a Heuristic, the proto of the connection otherwise
+//	host    string  the trusted host when checked as a Heuristic, the host of the request otherwise
+//	method  string  the request method
+//	tls     bool    whether the connection to this server is TLS
+//
+// An ip is tested with "in" against a named whitelist given to CompilePolicy or a quoted list of networks,
+// e.g. peer in "10.0.0.0/8,192.168.0.0/16". The ints compare with ==, !=, <, <=, > and >=, the strings and
+// the other values only with == and !=, strings case-insensitively. Strings are quoted, except the bare http
+// and https. The conditions combine with &&, || and ! and group with parentheses.
+type Policy struct {
+	src  string
+	eval func(env *policyEnv) bool
+}
+
+// PolicyExtractor delegates to Extractor when the policy holds for the request, and treats the request as
+// not coming from a trusted proxy otherwise.
+type PolicyExtractor struct {
+	Policy    *Policy
+	Extractor IPExtractor
+}
+
+type policyEnv struct {
+	peer   net.IP
+	remote net.IP
+	hops   int
+	proto  string
+	host   string
+	method string
+	tls    bool
+}
+
+// CompilePolicy compiles the expression, sets is the named whitelists the ips can be tested against.
+func CompilePolicy(src string, sets map[string]*CIDRWhitelist) (*Policy, error) {
+	tokens, err := lexPolicy(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &policyParser{tokens: tokens, sets: sets}
+	eval, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("policy: unexpected %q", p.tokens[p.pos].text)
+	}
+	return &Policy{src: src, eval: eval}, nil
+}
+
+// String returns the source of the policy.
+func (p *Policy) String() string {
+	return p.src
+}
+
+// Check reports the request if the policy does not hold for the resolved values, so the policy can be used
+// as a Heuristic.
+func (p *Policy) Check(r *http.Request, res Result) error {
+	env := &policyEnv{
+		remote: res.RemoteAddr,
+		hops:   len(res.ForwardedFor) + 1 + len(res.Proxies),
+		proto:  res.Proto,
+		host:   res.Host,
+		tls:    r.TLS != nil,
+		method: r.Method,
+	}
+	env.peer = res.RemoteAddr
+	if len(res.Proxies) > 0 {
+		env.peer = res.Proxies[len(res.Proxies)-1]
+	}
+	if !p.eval(env) {
+		return fmt.Errorf("policy %q does not hold", p.src)
+	}
+	return nil
+}
+
+// Resolve treats the request as not coming from a trusted proxy since the request is not available.
+func (e *PolicyExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return nil, remote, forwarded, nil
+}
+
+func (e *PolicyExtractor) ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if r == nil || e.Policy == nil || e.Extractor == nil {
+		return nil, remote.IP, forwarded, nil
+	}
+	env := &policyEnv{
+		peer:   remote.IP,
+		hops:   len(forwarded) + 1,
+		proto:  "http",
+		host:   r.Host,
+		method: r.Method,
+		tls:    r.TLS != nil,
+	}
+	if env.tls {
+		env.proto = "https"
+	}
+	if !e.Policy.eval(env) {
+		return nil, remote.IP, forwarded, nil
+	}
+	return resolveRequest(e.Extractor, r, remote, forwarded)
+}
+
+type policyType uint
+
+const (
+	policyBool policyType = iota
+	policyInt
+	policyString
+	policyIP
+)
+
+func (t policyType) String() string {
+	switch t {
+	case policyBool:
+		return "bool"
+	case policyInt:
+		return "int"
+	case policyString:
+		return "string"
+	case policyIP:
+		return "ip"
+	}
+	return fmt.Sprintf("policyType(%d)", uint(t))
+}
+
+// policyOperand is a variable or a literal with its type
+type policyOperand struct {
+	typ policyType
+	get func(env *policyEnv) any
+}
+
+var policyVars = map[string]policyOperand{
+	"peer":   {policyIP, func(env *policyEnv) any { return env.peer }},
+	"remote": {policyIP, func(env *policyEnv) any { return env.remote }},
+	"hops":   {policyInt, func(env *policyEnv) any { return env.hops }},
+	"proto":  {policyString, func(env *policyEnv) any { return env.proto }},
+	"host":   {policyString, func(env *policyEnv) any { return env.host }},
+	"method": {policyString, func(env *policyEnv) any { return env.method }},
+	"tls":    {policyBool, func(env *policyEnv) any { return env.tls }},
+}
+
+type policyTokenKind uint
+
+const (
+	policyIdent policyTokenKind = iota
+	policyNumber
+	policyQuoted
+	policyOp
+)
+
+type policyToken struct {
+	kind policyTokenKind
+	text string
+}
+
+func lexPolicy(src string) ([]policyToken, error) {
+	var res []policyToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("policy: unterminated string at %d", i)
+			}
+			s, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("policy: invalid string at %d: %w", i, err)
+			}
+			res = append(res, policyToken{policyQuoted, s})
+			i = end + 1
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(src) && src[end] >= '0' && src[end] <= '9' {
+				end++
+			}
+			res = append(res, policyToken{policyNumber, src[i:end]})
+			i = end
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			end := i
+			for end < len(src) && (src[end] == '_' || src[end] == '-' || src[end] >= 'a' && src[end] <= 'z' ||
+				src[end] >= 'A' && src[end] <= 'Z' || src[end] >= '0' && src[end] <= '9') {
+				end++
+			}
+			res = append(res, policyToken{policyIdent, src[i:end]})
+			i = end
+		default:
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("policy: unexpected %q at %d", c, i)
+			}
+			res = append(res, policyToken{policyOp, op})
+			i += len(op)
+		}
+	}
+	return res, nil
+}
+
+type policyParser struct {
+	tokens []policyToken
+	pos    int
+	sets   map[string]*CIDRWhitelist
+}
+
+func (p *policyParser) peek() (policyToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return policyToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *policyParser) accept(kind policyTokenKind, text string) bool {
+	if t, ok := p.peek(); ok && t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *policyParser) parseOr() (func(env *policyEnv) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(policyOp, "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *policyEnv) bool { return l(env) || right(env) }
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseAnd() (func(env *policyEnv) bool, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(policyOp, "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *policyEnv) bool { return l(env) && right(env) }
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseUnary() (func(env *policyEnv) bool, error) {
+	if p.accept(policyOp, "!") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *policyEnv) bool { return !inner(env) }, nil
+	}
+	if p.accept(policyOp, "(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(policyOp, ")") {
+			return nil, fmt.Errorf("policy: missing )")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *policyParser) parseComparison() (func(env *policyEnv) bool, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept(policyIdent, "in") {
+		if left.typ != policyIP {
+			return nil, fmt.Errorf("policy: in needs an ip, got %s", left.typ)
+		}
+		set, err := p.parseSet()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *policyEnv) bool {
+			ip, _ := left.get(env).(net.IP)
+			return ip != nil && set.Contains(ip)
+		}, nil
+	}
+	t, ok := p.peek()
+	if !ok || t.kind != policyOp || !isPolicyComparison(t.text) {
+		if left.typ != policyBool {
+			return nil, fmt.Errorf("policy: %s is not a condition", left.typ)
+		}
+		return func(env *policyEnv) bool { return left.get(env).(bool) }, nil
+	}
+	p.pos++
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if left.typ != right.typ {
+		return nil, fmt.Errorf("policy: cannot compare %s with %s", left.typ, right.typ)
+	}
+	op := t.text
+	if left.typ != policyInt && op != "==" && op != "!=" {
+		return nil, fmt.Errorf("policy: %s does not support %s", left.typ, op)
+	}
+	return func(env *policyEnv) bool {
+		return comparePolicy(left.typ, op, left.get(env), right.get(env))
+	}, nil
+}
+
+func (p *policyParser) parseOperand() (policyOperand, error) {
+	t, ok := p.peek()
+	if !ok {
+		return policyOperand{}, fmt.Errorf("policy: unexpected end")
+	}
+	p.pos++
+	switch t.kind {
+	case policyNumber:
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return policyOperand{}, fmt.Errorf("policy: invalid number %q", t.text)
+		}
+		return policyOperand{policyInt, func(*policyEnv) any { return n }}, nil
+	case policyQuoted:
+		s := t.text
+		if ip := net.ParseIP(s); ip != nil {
+			return policyOperand{policyIP, func(*policyEnv) any { return ip }}, nil
+		}
+		return policyOperand{policyString, func(*policyEnv) any { return s }}, nil
+	case policyIdent:
+		if v, ok := policyVars[t.text]; ok {
+			return v, nil
+		}
+		switch s := t.text; s {
+		case "http", "https":
+			return policyOperand{policyString, func(*policyEnv) any { return s }}, nil
+		case "true", "false":
+			b := s == "true"
+			return policyOperand{policyBool, func(*policyEnv) any { return b }}, nil
+		}
+		return policyOperand{}, fmt.Errorf("policy: unknown variable %q", t.text)
+	}
+	return policyOperand{}, fmt.Errorf("policy: unexpected %q", t.text)
+}
+
+func (p *policyParser) parseSet() (*CIDRWhitelist, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("policy: missing whitelist after in")
+	}
+	p.pos++
+	switch t.kind {
+	case policyIdent:
+		set, ok := p.sets[t.text]
+		if !ok || set == nil {
+			return nil, fmt.Errorf("policy: unknown whitelist %q", t.text)
+		}
+		return set, nil
+	case policyQuoted:
+		set := &CIDRWhitelist{}
+		if err := set.UnmarshalText([]byte(t.text)); err != nil {
+			return nil, fmt.Errorf("policy: %w", err)
+		}
+		return set, nil
+	}
+	return nil, fmt.Errorf("policy: unexpected %q after in", t.text)
+}
+
+func isPolicyComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func comparePolicy(typ policyType, op string, a any, b any) bool {
+	var eq bool
+	switch typ {
+	case policyInt:
+		x, y := a.(int), b.(int)
+		switch op {
+		case "<":
+			return x < y
+		case "<=":
+			return x <= y
+		case ">":
+			return x > y
+		case ">=":
+			return x >= y
+		}
+		eq = x == y
+	case policyString:
+		eq = strings.EqualFold(a.(string), b.(string))
+	case policyIP:
+		x, _ := a.(net.IP)
+		y, _ := b.(net.IP)
+		eq = x != nil && x.Equal(y)
+	case policyBool:
+		eq = a.(bool) == b.(bool)
+	}
+	if op == "!=" {
+		return !eq
+	}
+	return eq
+}
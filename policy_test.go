@@ -0,0 +1,123 @@
+package trustedproxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompilePolicyErrors(t *testing.T) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := map[string]*CIDRWhitelist{"cdn": cdn}
+	for _, src := range []string{
+		"",
+		"nope == 1",
+		"peer in missing",
+		`peer in "not-a-network"`,
+		"hops == https",
+		"peer < 3",
+		"proto > https",
+		`host == "example.com`,
+		"hops <",
+		"(tls",
+		"tls)",
+		"tls && ",
+		"hops == 3 hops",
+	} {
+		if _, err := CompilePolicy(src, sets); err == nil {
+			t.Errorf("%q compiled, want an error", src)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := map[string]*CIDRWhitelist{"cdn": cdn}
+	trusted := Result{
+		RemoteAddr:   net.ParseIP("203.0.113.7"),
+		ForwardedFor: Chain{net.ParseIP("192.0.2.66")},
+		Proxies:      Chain{net.ParseIP("10.0.0.5"), net.ParseIP("10.0.0.2")},
+		Host:         "Example.com",
+		Proto:        "https",
+	}
+	tests := []struct {
+		src   string
+		tls   bool
+		holds bool
+	}{
+		{"peer in cdn && hops <= 4 && proto == https", false, true},
+		{"peer in cdn && hops < 4", false, false},
+		{`peer in "192.168.0.0/16, 10.0.0.0/24"`, false, true},
+		{`remote in cdn`, false, false},
+		{`!(remote in cdn)`, false, true},
+		{`host == "example.COM"`, false, true},
+		{`host != "example.com" || method == "get"`, false, true},
+		{"tls", false, false},
+		{"tls", true, true},
+		{"proto == http || tls && hops > 10", true, false},
+		{"(proto == http || tls) && hops >= 4", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			p, err := CompilePolicy(tt.src, sets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if err := p.Check(r, trusted); (err == nil) != tt.holds {
+				t.Errorf("check returned %v, want holds %v", err, tt.holds)
+			}
+		})
+	}
+}
+
+func TestPolicyExtractor(t *testing.T) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := CompilePolicy("tls && hops <= 2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		tls    bool
+		xff    string
+		remote string
+	}{
+		{"policy holds", true, "203.0.113.7", "203.0.113.7"},
+		{"no tls", false, "203.0.113.7", "10.0.0.2"},
+		{"too many hops", true, "203.0.113.7, 10.0.0.5", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTTPHandler{Extractor: &PolicyExtractor{Policy: p, Extractor: cdn}}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = "10.0.0.2:4711"
+			r.Header.Set("X-Forwarded-For", tt.xff)
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			var remote string
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, _ := FromContext(r.Context())
+				remote = f.GetTrustedRemoteAddr().String()
+			}))
+			if remote != tt.remote {
+				t.Errorf("remote %q, want %q", remote, tt.remote)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package trustedproxy
+
+import (
+	"net"
+	"net/http"
+)
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Min uint16
+	Max uint16
+}
+
+// TrustEntry is a trusted network, optionally restricted to the source ports the proxy connects from.
+type TrustEntry struct {
+	Network *net.IPNet
+
+	// Ports restricts the source port of the immediate peer, empty means any port.
+	Ports []PortRange
+}
+
+// PortAwareWhitelist works like CIDRWhitelist but an entry with Ports only trusts the immediate peer when
+// its source port is in one of the ranges, e.g. a HAProxy connecting from a pinned port range. The rest of
+// the ip chain carries no port, so only the entries without Ports apply to them.
+type PortAwareWhitelist struct {
+	Entries []TrustEntry
+}
+
+func (p *PortAwareWhitelist) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return p.ResolveRequest(nil, &net.TCPAddr{IP: remote}, forwarded)
+}
+
+func (p *PortAwareWhitelist) ResolveRequest(_ *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if len(forwarded) == 0 || !p.Contains(remote.IP, remote.Port) {
+		return nil, remote.IP, forwarded, nil
+	}
+	proxy := remote.IP
+	client, forwarded := pop(forwarded)
+	for len(forwarded) > 0 {
+		if !p.Contains(client, 0) {
+			break
+		}
+		proxy = client
+		client, forwarded = pop(forwarded)
+	}
+	return proxy, client, forwarded, nil
+}
+
+// Contains returns true if the ip is trusted when connecting from the port, 0 means the port is unknown
+// and only matches the entries without Ports.
+func (p *PortAwareWhitelist) Contains(ip net.IP, port int) bool {
+	for _, entry := range p.Entries {
+		if !entry.Network.Contains(ip) {
+			continue
+		}
+		if len(entry.Ports) == 0 {
+			return true
+		}
+		for _, r := range entry.Ports {
+			if port != 0 && port >= int(r.Min) && port <= int(r.Max) {
+				return true
+			}
+		}
+	}
+	return false
+}
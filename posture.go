@@ -0,0 +1,29 @@
+package trustedproxy
+
+// TLSPosture is the effective TLS state of a request from the client to this server.
+type TLSPosture struct {
+	// ClientProto is the protocol between the client and the edge, it is the forwarded proto when behind a
+	// trusted proxy, otherwise the protocol of the connection. Unlike GetTrustedProto it is never pinned to
+	// "https" by NoProtoDowngrade.
+	ClientProto string
+
+	// LocalTLS is true if the connection to this server is over TLS.
+	LocalTLS bool
+
+	// BehindProxy is true if the request is coming from a trusted proxy, the hops between the edge and the
+	// immediate proxy are not known and only the edge and the last hop are reflected.
+	BehindProxy bool
+}
+
+// EndToEnd returns true if both the client to the edge and the last hop to this server are over TLS.
+func (p TLSPosture) EndToEnd() bool {
+	return p.ClientProto == "https" && p.LocalTLS
+}
+
+func (f *forwardedRequest) GetTLSPosture() TLSPosture {
+	return TLSPosture{
+		ClientProto: f.forwardedProto,
+		LocalTLS:    f.TLS != nil,
+		BehindProxy: f.proxyIP != nil,
+	}
+}
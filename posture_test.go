@@ -0,0 +1,54 @@
+package trustedproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTLSPosture(t *testing.T) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		noDowngrade bool
+		peer        string
+		proto       string
+		localTLS    bool
+		trusted     string
+		client      string
+		endToEnd    bool
+	}{
+		{"end to end", false, "10.0.0.2:4711", "https", true, "https", "https", true},
+		{"plaintext client leg", false, "10.0.0.2:4711", "http", true, "http", "http", false},
+		{"pinned plaintext client leg", true, "10.0.0.2:4711", "http", true, "https", "http", false},
+		{"pinned end to end", true, "10.0.0.2:4711", "https", true, "https", "https", true},
+		{"plaintext last hop", true, "10.0.0.2:4711", "https", false, "https", "https", false},
+		{"direct tls", true, "203.0.113.7:4711", "http", true, "https", "https", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTTPHandler{Extractor: cdn, NoProtoDowngrade: tt.noDowngrade}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.peer
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+			if tt.localTLS {
+				r.TLS = &tls.ConnectionState{}
+			}
+			var trusted string
+			var posture TLSPosture
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, _ := FromContext(r.Context())
+				trusted, posture = f.GetTrustedProto(), f.GetTLSPosture()
+			}))
+			if trusted != tt.trusted || posture.ClientProto != tt.client || posture.EndToEnd() != tt.endToEnd {
+				t.Errorf("trusted proto %q, posture %+v end to end %v, want %q %q %v",
+					trusted, posture, posture.EndToEnd(), tt.trusted, tt.client, tt.endToEnd)
+			}
+		})
+	}
+}
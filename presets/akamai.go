@@ -0,0 +1,23 @@
+package presets
+
+import (
+	"github.com/eslym/trustedproxy"
+)
+
+// Akamai returns an extractor of the True-Client-IP header trusting the Akamai peers. Akamai does not publish
+// its edge ranges, the ones reaching an origin are the customer specific Site Shield map of the property,
+// including the staging network when it is used for testing, so there is no built-in list.
+func Akamai(peers *trustedproxy.CIDRWhitelist) *trustedproxy.TrustedHeaderExtractor {
+	return trustedproxy.TrueClientIP(peers)
+}
+
+// AkamaiAutoUpdate returns the Akamai extractor refreshed from the mirrors of the Site Shield map, e.g. an
+// export kept in an internal bucket, in any format accepted by trustedproxy.ParseRemoteWhitelist. Set Parse
+// for other formats, then call Refresh before serving and Run in a goroutine to keep it current.
+func AkamaiAutoUpdate(urls ...string) *trustedproxy.RemoteWhitelist {
+	w := trustedproxy.NewRemoteWhitelist(urls...)
+	w.Extractor = func(whitelist *trustedproxy.CIDRWhitelist) trustedproxy.IPExtractor {
+		return Akamai(whitelist)
+	}
+	return w
+}
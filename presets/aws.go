@@ -0,0 +1,78 @@
+package presets
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/eslym/trustedproxy"
+)
+
+// AWSIPRangesURL is the feed of the AWS ip ranges.
+// see https://docs.aws.amazon.com/vpc/latest/userguide/aws-ip-ranges.html
+const AWSIPRangesURL = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+
+// CloudFrontService is the service of the ranges CloudFront uses to reach the origins, it is narrower than
+// the "CLOUDFRONT" service which also covers the edge locations facing the viewers.
+const CloudFrontService = "CLOUDFRONT_ORIGIN_FACING"
+
+type awsIPRanges struct {
+	Prefixes []struct {
+		IPPrefix string `json:"ip_prefix"`
+		Region   string `json:"region"`
+		Service  string `json:"service"`
+	} `json:"prefixes"`
+	IPv6Prefixes []struct {
+		IPv6Prefix string `json:"ipv6_prefix"`
+		Region     string `json:"region"`
+		Service    string `json:"service"`
+	} `json:"ipv6_prefixes"`
+}
+
+// ParseAWSIPRanges returns a parser of the AWS ip-ranges.json feed for RemoteWhitelist.Parse, keeping the
+// ranges of the service in the regions, every region is kept if none is given. The service and the regions
+// are compared case-insensitively, CloudFront ranges are in the "GLOBAL" region.
+func ParseAWSIPRanges(service string, regions ...string) func(body []byte) (*trustedproxy.CIDRWhitelist, error) {
+	return func(body []byte) (*trustedproxy.CIDRWhitelist, error) {
+		var doc awsIPRanges
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("invalid aws ip ranges: %w", err)
+		}
+		var cidrs []string
+		for _, p := range doc.Prefixes {
+			if matchAWS(p.Service, p.Region, service, regions) {
+				cidrs = append(cidrs, p.IPPrefix)
+			}
+		}
+		for _, p := range doc.IPv6Prefixes {
+			if matchAWS(p.Service, p.Region, service, regions) {
+				cidrs = append(cidrs, p.IPv6Prefix)
+			}
+		}
+		return trustedproxy.NewCIDRWhitelist(cidrs...)
+	}
+}
+
+// CloudFront returns a whitelist of the CloudFront origin facing ranges refreshed from AWSIPRangesURL, the
+// list is too long and changes too often to be built in, so it trusts nobody until the first refresh. Call
+// Refresh before serving and Run in a goroutine to keep it current.
+func CloudFront() *trustedproxy.RemoteWhitelist {
+	w := trustedproxy.NewRemoteWhitelist(AWSIPRangesURL)
+	w.Parse = ParseAWSIPRanges(CloudFrontService)
+	return w
+}
+
+func matchAWS(service string, region string, wantService string, wantRegions []string) bool {
+	if !strings.EqualFold(service, wantService) {
+		return false
+	}
+	if len(wantRegions) == 0 {
+		return true
+	}
+	for _, r := range wantRegions {
+		if strings.EqualFold(region, r) {
+			return true
+		}
+	}
+	return false
+}
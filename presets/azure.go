@@ -0,0 +1,67 @@
+package presets
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/eslym/trustedproxy"
+)
+
+// AzureFrontDoorNetworks returns a whitelist of the AzureFrontDoor.Backend ranges Azure Front Door connects to
+// the origins from.
+// see https://learn.microsoft.com/en-us/azure/frontdoor/origin-security
+func AzureFrontDoorNetworks() *trustedproxy.CIDRWhitelist {
+	return mustWhitelist(
+		"147.243.0.0/16",
+		"2a01:111:2050::/44",
+	)
+}
+
+// AzureFrontDoorExtractor trusts the client ip header of Azure Front Door when the immediate peer is in Peers.
+// The ranges are shared by every Front Door profile, so FrontDoorID should be set to reject the requests
+// sent through the profiles of others.
+// see https://learn.microsoft.com/en-us/azure/frontdoor/front-door-http-headers-protocol
+type AzureFrontDoorExtractor struct {
+	// Peers is the whitelist of the Front Door backends.
+	Peers *trustedproxy.CIDRWhitelist
+
+	// FrontDoorID is the id of the Front Door profile required in the X-Azure-FDID header, any profile is
+	// accepted if it is empty.
+	FrontDoorID string
+
+	// UseClientIP reads X-Azure-ClientIP instead of X-Azure-SocketIP. The socket ip is the peer of the
+	// connection to Front Door, while the client ip is derived from the X-Forwarded-For header the client
+	// sent, so it is only correct when the clients reach Front Door through proxies of their own.
+	UseClientIP bool
+}
+
+// AzureFrontDoor returns an extractor of the X-Azure-SocketIP header trusting AzureFrontDoorNetworks and
+// requiring the Front Door profile id, any profile is accepted if the id is empty.
+func AzureFrontDoor(frontDoorID string) *AzureFrontDoorExtractor {
+	return &AzureFrontDoorExtractor{Peers: AzureFrontDoorNetworks(), FrontDoorID: frontDoorID}
+}
+
+// AzureApplicationGateway returns a whitelist of the subnets of the Application Gateway instances, which live
+// in the virtual network of the application. The gateway appends the client ip with its port to the
+// X-Forwarded-For header, e.g. "203.0.113.7:51234", the port is dropped when the chain is parsed.
+func AzureApplicationGateway(subnets ...string) (*trustedproxy.CIDRWhitelist, error) {
+	return trustedproxy.NewCIDRWhitelist(subnets...)
+}
+
+// Resolve treats the request as not coming from a trusted proxy since the headers are not available.
+func (a *AzureFrontDoorExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return nil, remote, forwarded, nil
+}
+
+func (a *AzureFrontDoorExtractor) ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	if r == nil || (a.FrontDoorID != "" && !strings.EqualFold(strings.TrimSpace(r.Header.Get("X-Azure-FDID")), a.FrontDoorID)) {
+		return nil, remote.IP, forwarded, nil
+	}
+	header := "X-Azure-SocketIP"
+	if a.UseClientIP {
+		header = "X-Azure-ClientIP"
+	}
+	e := &trustedproxy.TrustedHeaderExtractor{Header: header, Peers: a.Peers}
+	return e.ResolveRequest(r, remote, forwarded)
+}
@@ -0,0 +1,79 @@
+package presets
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/eslym/trustedproxy"
+)
+
+// CloudflareURL is the Cloudflare API endpoint listing its ip ranges.
+// see https://developers.cloudflare.com/api/operations/cloudflare-i-ps-cloudflare-ip-details
+const CloudflareURL = "https://api.cloudflare.com/client/v4/ips"
+
+// CloudflareNetworks returns a whitelist of the Cloudflare ip ranges.
+// see https://www.cloudflare.com/ips/
+func CloudflareNetworks() *trustedproxy.CIDRWhitelist {
+	return mustWhitelist(
+		"173.245.48.0/20",
+		"103.21.244.0/22",
+		"103.22.200.0/22",
+		"103.31.4.0/22",
+		"141.101.64.0/18",
+		"108.162.192.0/18",
+		"190.93.240.0/20",
+		"188.114.96.0/20",
+		"197.234.240.0/22",
+		"198.41.128.0/17",
+		"162.158.0.0/15",
+		"104.16.0.0/13",
+		"104.24.0.0/14",
+		"172.64.0.0/13",
+		"131.0.72.0/22",
+		"2400:cb00::/32",
+		"2606:4700::/32",
+		"2803:f800::/32",
+		"2405:b500::/32",
+		"2405:8100::/32",
+		"2a06:98c0::/29",
+		"2c0f:f248::/32",
+	)
+}
+
+// Cloudflare returns an extractor of the CF-Connecting-IP header trusting the Cloudflare ip ranges.
+func Cloudflare() *trustedproxy.TrustedHeaderExtractor {
+	return trustedproxy.CloudflareConnectingIP(CloudflareNetworks())
+}
+
+// CloudflareAutoUpdate returns the Cloudflare extractor seeded with CloudflareNetworks and refreshed from
+// CloudflareURL with ParseCloudflareIPs, call Run in a goroutine to keep it current.
+func CloudflareAutoUpdate() *trustedproxy.RemoteWhitelist {
+	w := trustedproxy.NewRemoteWhitelist(CloudflareURL)
+	w.Extractor = func(whitelist *trustedproxy.CIDRWhitelist) trustedproxy.IPExtractor {
+		return trustedproxy.CloudflareConnectingIP(whitelist)
+	}
+	w.Parse = ParseCloudflareIPs
+	if err := w.Set(CloudflareNetworks()); err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// ParseCloudflareIPs parses the response of CloudflareURL for RemoteWhitelist.Parse, only the
+// result.ipv4_cidrs and result.ipv6_cidrs are read and a failed or differently shaped response is rejected.
+func ParseCloudflareIPs(body []byte) (*trustedproxy.CIDRWhitelist, error) {
+	var doc struct {
+		Success bool `json:"success"`
+		Result  *struct {
+			IPv4CIDRs []string `json:"ipv4_cidrs"`
+			IPv6CIDRs []string `json:"ipv6_cidrs"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid cloudflare ips: %w", err)
+	}
+	if !doc.Success || doc.Result == nil || len(doc.Result.IPv4CIDRs) == 0 || len(doc.Result.IPv6CIDRs) == 0 {
+		return nil, fmt.Errorf("invalid cloudflare ips: missing result.ipv4_cidrs or result.ipv6_cidrs")
+	}
+	return trustedproxy.NewCIDRWhitelist(append(doc.Result.IPv4CIDRs, doc.Result.IPv6CIDRs...)...)
+}
@@ -0,0 +1,36 @@
+package presets
+
+import "testing"
+
+func TestParseCloudflareIPs(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    int
+		wantErr bool
+	}{
+		{"api response", `{"result": {"ipv4_cidrs": ["173.245.48.0/20"], "ipv6_cidrs": ["2400:cb00::/32"], "etag": "x"}, "success": true, "errors": [], "messages": []}`, 2, false},
+		{"failed response", `{"result": null, "success": false, "errors": [{"code": 10000, "message": "from 192.0.2.1"}]}`, 0, true},
+		{"unsuccessful with result", `{"result": {"ipv4_cidrs": ["173.245.48.0/20"], "ipv6_cidrs": ["2400:cb00::/32"]}, "success": false}`, 0, true},
+		{"changed envelope", `{"ipv4_cidrs": ["173.245.48.0/20"], "ipv6_cidrs": ["2400:cb00::/32"], "success": true}`, 0, true},
+		{"missing family", `{"result": {"ipv4_cidrs": ["173.245.48.0/20"]}, "success": true}`, 0, true},
+		{"invalid entry", `{"result": {"ipv4_cidrs": ["nope"], "ipv6_cidrs": ["2400:cb00::/32"]}, "success": true}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCloudflareIPs([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsed %v, want an error", got.Whitelist)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Whitelist) != tt.want {
+				t.Errorf("parsed %d networks, want %d", len(got.Whitelist), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package presets
+
+import (
+	"net"
+
+	"github.com/eslym/trustedproxy"
+)
+
+// DigitalOceanVPC returns a whitelist of the ranges of the VPCs the DigitalOcean load balancers live in, e.g.
+// "10.104.0.0/20". The load balancers connect to the droplets from their private address in the VPC, the
+// ranges are chosen per VPC so there is no built-in list.
+// see https://docs.digitalocean.com/products/networking/vpc/
+func DigitalOceanVPC(cidrs ...string) (*trustedproxy.CIDRWhitelist, error) {
+	return trustedproxy.NewCIDRWhitelist(cidrs...)
+}
+
+// DigitalOceanLB returns an extractor for the DigitalOcean load balancers with the http forwarding rules,
+// exactly the last ip of the X-Forwarded-For chain appended by the load balancer is taken when the peer is
+// in the VPC.
+// see https://docs.digitalocean.com/products/networking/load-balancers/
+func DigitalOceanLB(vpc *trustedproxy.CIDRWhitelist) *trustedproxy.SingleHopWhitelist {
+	return &trustedproxy.SingleHopWhitelist{Whitelist: vpc}
+}
+
+// DigitalOceanProxyProtocol wraps the listener for the load balancers with the PROXY protocol enabled, the
+// preamble is only read from the peers in the VPC and the client becomes the RemoteAddr of the connection.
+// The forwarding headers then come from the client itself, use the listener with trustedproxy.TrustNone.
+func DigitalOceanProxyProtocol(l net.Listener, vpc *trustedproxy.CIDRWhitelist) *trustedproxy.ProxyProtoListener {
+	return &trustedproxy.ProxyProtoListener{Listener: l, Trusted: vpc}
+}
@@ -0,0 +1,43 @@
+package presets_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/eslym/trustedproxy"
+	"github.com/eslym/trustedproxy/presets"
+)
+
+func ExampleCloudflare() {
+	handler := trustedproxy.WithTrustedRequest(presets.Cloudflare(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.RemoteAddr)
+	}))
+
+	// a request from a Cloudflare edge
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "173.245.48.1:51234"
+	r.Header.Set("CF-Connecting-IP", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	// the header is ignored from anybody else
+	r = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "198.51.100.9:51234"
+	r.Header.Set("CF-Connecting-IP", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	// Output:
+	// 203.0.113.7
+	// 198.51.100.9
+}
+
+func ExampleHeroku() {
+	handler := trustedproxy.WithTrustedRequest(presets.Heroku(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.RemoteAddr)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "10.1.2.3:51234"
+	r.Header.Set("X-Forwarded-For", "192.0.2.66, 203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	// Output: 203.0.113.7
+}
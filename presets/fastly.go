@@ -0,0 +1,61 @@
+package presets
+
+import (
+	"github.com/eslym/trustedproxy"
+)
+
+// FastlyURL is the Fastly API endpoint listing its ip ranges.
+// see https://www.fastly.com/documentation/reference/api/utils/public-ip-list/
+const FastlyURL = "https://api.fastly.com/public-ip-list"
+
+// FastlyNetworks returns a whitelist of the Fastly ip ranges.
+func FastlyNetworks() *trustedproxy.CIDRWhitelist {
+	return mustWhitelist(
+		"23.235.32.0/20",
+		"43.249.72.0/22",
+		"103.244.50.0/24",
+		"103.245.222.0/23",
+		"103.245.224.0/24",
+		"104.156.80.0/20",
+		"140.248.64.0/18",
+		"140.248.128.0/17",
+		"146.75.0.0/17",
+		"151.101.0.0/16",
+		"157.52.64.0/18",
+		"167.82.0.0/17",
+		"167.82.128.0/20",
+		"167.82.160.0/20",
+		"167.82.224.0/20",
+		"172.111.64.0/18",
+		"185.31.16.0/22",
+		"199.27.72.0/21",
+		"199.232.0.0/16",
+		"2a04:4e40::/32",
+		"2a04:4e42::/32",
+	)
+}
+
+// FastlyClientIP returns a TrustedHeaderExtractor of the Fastly-Client-IP header, peers should be the
+// Fastly ip ranges. Fastly passes the header on when the client sends it, so the service must overwrite it
+// with `set req.http.Fastly-Client-IP = client.ip;` in vcl_recv of the edge, before any shielding.
+func FastlyClientIP(peers *trustedproxy.CIDRWhitelist) *trustedproxy.TrustedHeaderExtractor {
+	return &trustedproxy.TrustedHeaderExtractor{Header: "Fastly-Client-IP", Peers: peers}
+}
+
+// Fastly returns an extractor of the Fastly-Client-IP header trusting the Fastly ip ranges.
+func Fastly() *trustedproxy.TrustedHeaderExtractor {
+	return FastlyClientIP(FastlyNetworks())
+}
+
+// FastlyAutoUpdate returns the Fastly extractor seeded with FastlyNetworks and refreshed from FastlyURL,
+// call Run in a goroutine to keep it current.
+func FastlyAutoUpdate() *trustedproxy.RemoteWhitelist {
+	w := trustedproxy.NewRemoteWhitelist(FastlyURL)
+	w.Extractor = func(whitelist *trustedproxy.CIDRWhitelist) trustedproxy.IPExtractor {
+		return FastlyClientIP(whitelist)
+	}
+	if err := w.Set(FastlyNetworks()); err != nil {
+		panic(err)
+	}
+	return w
+}
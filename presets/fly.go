@@ -0,0 +1,34 @@
+package presets
+
+import (
+	"github.com/eslym/trustedproxy"
+)
+
+// FlyNetworks returns a whitelist of the private ranges the Fly.io proxy connects to the machines from, the
+// IPv4 range of the machine network and the 6PN IPv6 range. The anycast ips of the edge are never the peer,
+// the proxy terminates the connections there. The ranges are private, so every machine of the organization
+// able to reach the app is trusted as well.
+// see https://fly.io/docs/networking/private-networking/
+func FlyNetworks() *trustedproxy.CIDRWhitelist {
+	return mustWhitelist(
+		"172.16.0.0/12",
+		"fdaa::/16",
+	)
+}
+
+// FlyClientIP returns a TrustedHeaderExtractor of the Fly-Client-IP header, peers should be the Fly.io
+// private ranges.
+// see https://fly.io/docs/networking/request-headers/
+func FlyClientIP(peers *trustedproxy.CIDRWhitelist) *trustedproxy.TrustedHeaderExtractor {
+	return &trustedproxy.TrustedHeaderExtractor{Header: "Fly-Client-IP", Peers: peers}
+}
+
+// Fly returns an extractor of the Fly-Client-IP header trusting the Fly.io private ranges, falling back to the
+// last ip of the X-Forwarded-For chain appended by the proxy when the header is missing.
+func Fly() trustedproxy.ChainExtractor {
+	networks := FlyNetworks()
+	return trustedproxy.ChainExtractor{
+		FlyClientIP(networks),
+		&trustedproxy.SingleHopWhitelist{Whitelist: networks},
+	}
+}
@@ -0,0 +1,26 @@
+package presets
+
+import (
+	"github.com/eslym/trustedproxy"
+)
+
+// GoogleCloudLBNetworks returns a whitelist of the Google Front End ranges the Google Cloud load balancers
+// connect to the backends from.
+// see https://cloud.google.com/load-balancing/docs/health-checks#firewall_rules
+func GoogleCloudLBNetworks() *trustedproxy.CIDRWhitelist {
+	return mustWhitelist(
+		"35.191.0.0/16",
+		"130.211.0.0/22",
+	)
+}
+
+// GoogleCloudLB returns an extractor for the Google Cloud external application load balancers, which append
+// "<client-ip>, <lb-ip>" to the X-Forwarded-For header, so the client is the second entry from the right.
+// The offset is only applied to the requests coming from GoogleCloudLBNetworks.
+// see https://cloud.google.com/load-balancing/docs/https#x-forwarded-for_header
+func GoogleCloudLB() *trustedproxy.VerifiedOffsetIPExtractor {
+	return &trustedproxy.VerifiedOffsetIPExtractor{
+		Offset: 1,
+		Peers:  GoogleCloudLBNetworks(),
+	}
+}
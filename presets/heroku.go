@@ -0,0 +1,14 @@
+package presets
+
+import (
+	"github.com/eslym/trustedproxy"
+)
+
+// Heroku returns an extractor for the apps hosted on Heroku. The immediate peer is always the Heroku router,
+// which appends the client to the X-Forwarded-For header, but the router ips are not published, so exactly
+// one hop is trusted whatever the peer is and the last ip of the chain is the client. A request without the
+// header fails to resolve, it cannot have passed the router.
+// see https://devcenter.heroku.com/articles/http-routing#heroku-headers
+func Heroku() trustedproxy.TrustDepth {
+	return trustedproxy.TrustDepth(1)
+}
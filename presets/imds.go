@@ -0,0 +1,107 @@
+package presets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eslym/trustedproxy"
+)
+
+// DefaultAWSMetadataEndpoint is the endpoint of the EC2 instance metadata service.
+const DefaultAWSMetadataEndpoint = "http://169.254.169.254"
+
+// AWSMetadata queries the EC2 instance metadata service (IMDS), using IMDSv2 sessions with a fallback
+// to IMDSv1. It is reachable from EC2 instances and ECS tasks on EC2, but not from Fargate.
+// see https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instancedata-data-retrieval.html
+type AWSMetadata struct {
+	// Endpoint is the base URL of the service, DefaultAWSMetadataEndpoint is used if empty.
+	Endpoint string
+
+	// Client queries the service, a client with a 2 seconds timeout is used if it is nil.
+	Client *http.Client
+}
+
+// AWSVPC returns a whitelist of the CIDR blocks of the VPC the instance runs in, where the ALBs in front
+// of it live. It is a zero config setup for the ALB in front architecture, but it trusts every host of the
+// VPC, prefer the subnets of the load balancers when they are known.
+func AWSVPC(ctx context.Context) (*trustedproxy.CIDRWhitelist, error) {
+	return (&AWSMetadata{}).VPCNetworks(ctx)
+}
+
+// VPCNetworks returns a whitelist of the IPv4 and IPv6 CIDR blocks of the VPC of the primary network
+// interface.
+func (m *AWSMetadata) VPCNetworks(ctx context.Context) (*trustedproxy.CIDRWhitelist, error) {
+	token := m.token(ctx)
+	mac, err := m.get(ctx, token, "/latest/meta-data/mac")
+	if err != nil {
+		return nil, err
+	}
+	prefix := "/latest/meta-data/network/interfaces/macs/" + strings.TrimSpace(mac)
+	v4, err := m.get(ctx, token, prefix+"/vpc-ipv4-cidr-blocks")
+	if err != nil {
+		return nil, err
+	}
+	// the IPv6 blocks only exist for dual stack vpcs
+	v6, _ := m.get(ctx, token, prefix+"/vpc-ipv6-cidr-blocks")
+	cidrs := append(strings.Fields(v4), strings.Fields(v6)...)
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("no vpc cidr blocks in instance metadata")
+	}
+	return trustedproxy.NewCIDRWhitelist(cidrs...)
+}
+
+// token returns an IMDSv2 session token, "" if the service only supports IMDSv1
+func (m *AWSMetadata) token(ctx context.Context) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, m.endpoint()+"/latest/api/token", nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	body, err := m.do(req)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(body)
+}
+
+func (m *AWSMetadata) get(ctx context.Context, token string, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.endpoint()+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	return m.do(req)
+}
+
+func (m *AWSMetadata) do(req *http.Request) (string, error) {
+	client := m.Client
+	if client == nil {
+		client = &http.Client{Timeout: 2 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata %s: unexpected status %s", req.URL.Path, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func (m *AWSMetadata) endpoint() string {
+	if m.Endpoint == "" {
+		return DefaultAWSMetadataEndpoint
+	}
+	return strings.TrimSuffix(m.Endpoint, "/")
+}
@@ -0,0 +1,35 @@
+package presets
+
+import (
+	"github.com/eslym/trustedproxy"
+)
+
+// ImpervaNetworks returns a whitelist of the Imperva (formerly Incapsula) ip ranges.
+// see https://docs.imperva.com/bundle/z-kb-articles-km/page/c85245b7.html
+func ImpervaNetworks() *trustedproxy.CIDRWhitelist {
+	return mustWhitelist(
+		"199.83.128.0/21",
+		"198.143.32.0/19",
+		"149.126.72.0/21",
+		"103.28.248.0/22",
+		"185.11.124.0/22",
+		"192.230.64.0/18",
+		"45.64.64.0/22",
+		"107.154.0.0/16",
+		"45.60.0.0/16",
+		"45.223.0.0/16",
+		"131.125.128.0/17",
+		"2a02:e980::/29",
+	)
+}
+
+// IncapClientIP returns a TrustedHeaderExtractor of the Incap-Client-IP header, peers should be the Imperva
+// ip ranges.
+func IncapClientIP(peers *trustedproxy.CIDRWhitelist) *trustedproxy.TrustedHeaderExtractor {
+	return &trustedproxy.TrustedHeaderExtractor{Header: "Incap-Client-IP", Peers: peers}
+}
+
+// Imperva returns an extractor of the Incap-Client-IP header trusting the Imperva ip ranges.
+func Imperva() *trustedproxy.TrustedHeaderExtractor {
+	return IncapClientIP(ImpervaNetworks())
+}
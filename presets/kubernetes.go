@@ -0,0 +1,58 @@
+package presets
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/eslym/trustedproxy"
+)
+
+// IngressNginx returns the handler option for the applications behind ingress-nginx with the default
+// use-forwarded-headers: false, trusting cidrs, the ranges of the controller pods and of the load balancers
+// in front of them. The controller then replaces X-Forwarded-For with its own peer and moves the chain it
+// received to X-Original-Forwarded-For, IngressNginxChain joins them back into the whole chain.
+// With compute-full-forwarded-for enabled X-Forwarded-For is already the whole chain, use TraefikIngress.
+// see https://kubernetes.github.io/ingress-nginx/user-guide/nginx-configuration/configmap/#use-forwarded-headers
+func IngressNginx(cidrs ...string) (trustedproxy.HandlerOption, error) {
+	w, err := trustedproxy.NewCIDRWhitelist(cidrs...)
+	if err != nil {
+		return nil, err
+	}
+	return func(h *trustedproxy.HTTPHandler) {
+		h.Extractor = w
+		h.ChainSource = IngressNginxChain(h)
+	}, nil
+}
+
+// IngressNginxChain returns a ChainSource of the chain in X-Original-Forwarded-For followed by the one in
+// X-Forwarded-For, the headers are read as usual if X-Original-Forwarded-For is missing. Only the trusted
+// hops of the joined chain are taken, so a client sending X-Original-Forwarded-For itself gains nothing.
+// The joined chain is parsed by h.ParseChain, so StrictParsing, TolerantParsing and UnknownHops apply, a
+// malformed chain fails the request with ErrTypeChainSourceError.
+func IngressNginxChain(h *trustedproxy.HTTPHandler) trustedproxy.ChainSource {
+	return trustedproxy.ChainSourceFunc(func(r *http.Request) ([]net.IP, bool, error) {
+		original := r.Header.Values("X-Original-Forwarded-For")
+		if len(original) == 0 {
+			return nil, false, nil
+		}
+		ips, err := h.ParseChain(append(append([]string{}, original...), r.Header.Values("X-Forwarded-For")...))
+		if err != nil {
+			return nil, false, err
+		}
+		return ips, true, nil
+	})
+}
+
+// TraefikIngress returns the handler option for the applications behind the Traefik ingress controller,
+// trusting cidrs, the ranges of the controller pods and of the load balancers in front of them. Traefik
+// appends its peer to X-Forwarded-For like a plain reverse proxy.
+// see https://doc.traefik.io/traefik/routing/entrypoints/#forwarded-headers
+func TraefikIngress(cidrs ...string) (trustedproxy.HandlerOption, error) {
+	w, err := trustedproxy.NewCIDRWhitelist(cidrs...)
+	if err != nil {
+		return nil, err
+	}
+	return func(h *trustedproxy.HTTPHandler) {
+		h.Extractor = w
+	}, nil
+}
@@ -0,0 +1,60 @@
+package presets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eslym/trustedproxy"
+)
+
+func TestIngressNginx(t *testing.T) {
+	tests := []struct {
+		name     string
+		strict   bool
+		tolerant bool
+		unknown  trustedproxy.UnknownHopPolicy
+		original []string
+		xff      []string
+		remote   string
+		failed   bool
+	}{
+		{"joined chain", false, false, trustedproxy.UnknownHopSkip, []string{"203.0.113.7, 10.1.0.4"}, []string{"10.1.0.9"}, "203.0.113.7", false},
+		{"no original header", false, false, trustedproxy.UnknownHopSkip, nil, []string{"203.0.113.7"}, "203.0.113.7", false},
+		{"spoofed original header", false, false, trustedproxy.UnknownHopSkip, []string{"10.1.0.4"}, []string{"203.0.113.7"}, "203.0.113.7", false},
+		{"malformed entry dropped", false, false, trustedproxy.UnknownHopSkip, []string{"203.0.113.7, garbage"}, []string{"10.1.0.9"}, "203.0.113.7", false},
+		{"strict parsing", true, false, trustedproxy.UnknownHopSkip, []string{"203.0.113.7, garbage"}, []string{"10.1.0.9"}, "", true},
+		{"tolerant parsing", false, true, trustedproxy.UnknownHopSkip, []string{"192.0.2.66 203.0.113.7"}, []string{"10.1.0.9"}, "203.0.113.7", false},
+		{"unknown hop boundary", false, false, trustedproxy.UnknownHopBoundary, []string{"203.0.113.7, unknown"}, []string{"10.1.0.9"}, trustedproxy.UnknownHopIP.String(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := IngressNginx("10.1.0.0/16")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var errType trustedproxy.ErrorType
+			failed := false
+			var remote string
+			h := trustedproxy.NewHTTPHandler(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				remote = r.RemoteAddr
+			}), opt, trustedproxy.WithErrorHandler(func(et trustedproxy.ErrorType, err error, w http.ResponseWriter, r *http.Request) {
+				failed, errType = true, et
+			}))
+			h.StrictParsing = tt.strict
+			h.TolerantParsing = tt.tolerant
+			h.UnknownHops = tt.unknown
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = "10.1.0.2:4711"
+			r.Header["X-Original-Forwarded-For"] = tt.original
+			r.Header["X-Forwarded-For"] = tt.xff
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if failed != tt.failed || failed && errType != trustedproxy.ErrTypeChainSourceError {
+				t.Fatalf("failed %v with error type %v, want failed %v", failed, errType, tt.failed)
+			}
+			if remote != tt.remote {
+				t.Errorf("remote address %q, want %q", remote, tt.remote)
+			}
+		})
+	}
+}
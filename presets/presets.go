@@ -0,0 +1,18 @@
+// Package presets provides ready made extractors for the common edge providers, seeded with the ranges
+// published by the providers at the time of the release. The ranges change over time, prefer the auto
+// updated variants when the provider publishes them at a well-known URL.
+package presets
+
+import (
+	"github.com/eslym/trustedproxy"
+)
+
+// mustWhitelist returns a whitelist of the networks, it panics on an invalid network so it is only meant
+// for the built-in lists
+func mustWhitelist(cidrs ...string) *trustedproxy.CIDRWhitelist {
+	res, err := trustedproxy.NewCIDRWhitelist(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
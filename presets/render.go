@@ -0,0 +1,14 @@
+package presets
+
+import (
+	"github.com/eslym/trustedproxy"
+)
+
+// Render returns an extractor for the services hosted on Render. The Render proxy connects to the service from
+// the private network and appends the client to the X-Forwarded-For header, so exactly the last ip of the
+// chain is taken when the peer is private, the entries before it are sent by the client. Render does not
+// publish the ranges of the proxy, the whole private networks are trusted.
+// see https://render.com/docs/web-services#headers
+func Render() *trustedproxy.SingleHopWhitelist {
+	return &trustedproxy.SingleHopWhitelist{Whitelist: trustedproxy.PrivateNetworks()}
+}
@@ -0,0 +1,459 @@
+package trustedproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyProtoTimeout is the time given to the trusted peers to send the PROXY protocol preamble
+// when ProxyProtoListener.ReadHeaderTimeout is not set.
+const DefaultProxyProtoTimeout = 10 * time.Second
+
+var (
+	// CtxKeyProxyHeader is the context key for the ProxyProtoConn of a connection, see ProxyProtoConnContext,
+	// use ProxyHeaderFromContext to get its ProxyHeader.
+	CtxKeyProxyHeader = &contextKey{"proxy-header"}
+
+	proxyProtoV1Sig = []byte("PROXY ")
+	proxyProtoV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ProxyProtoListener accepts the HAProxy PROXY protocol v1 and v2 preambles from the trusted peers and
+// reports the address in the preamble as the RemoteAddr of the connection, so the same trust configuration
+// covers both L4 and L7 proxying. The preamble is only read from the peers in Trusted, connections from
+// other peers are passed through untouched, and a trusted peer without a preamble is treated as a direct
+// connection.
+// see https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+type ProxyProtoListener struct {
+	net.Listener
+
+	// Trusted is the peers allowed to send the preamble, nil trusts nobody.
+	Trusted *CIDRWhitelist
+
+	// ReadHeaderTimeout is the time given to read the preamble, DefaultProxyProtoTimeout if zero.
+	ReadHeaderTimeout time.Duration
+}
+
+// ProxyHeader is the parsed PROXY protocol preamble of a connection.
+type ProxyHeader struct {
+	// Version is the protocol version, 1 or 2.
+	Version int
+
+	// Local is true if the proxy sent the connection on its own behalf (v2 LOCAL command or v1 UNKNOWN),
+	// the connection is then reported with its real addresses.
+	Local bool
+
+	// Source and Destination are the addresses of the original connection, nil if Local.
+	Source      net.Addr
+	Destination net.Addr
+
+	// TLVs is the v2 type-length-value extensions in the order they were sent.
+	TLVs []ProxyTLV
+}
+
+// ProxyProtoConn is a connection accepted by ProxyProtoListener, the preamble is read on the first call
+// to Read, RemoteAddr or LocalAddr so a slow peer does not block the Accept loop.
+type ProxyProtoConn struct {
+	net.Conn
+
+	trusted bool
+	timeout time.Duration
+
+	once   sync.Once
+	reader *bufio.Reader
+	header *ProxyHeader
+	err    error
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *ProxyProtoListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.ReadHeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultProxyProtoTimeout
+	}
+	trusted := false
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok && l.Trusted != nil {
+		trusted = l.Trusted.Contains(addr.IP)
+	}
+	return &ProxyProtoConn{Conn: c, trusted: trusted, timeout: timeout}, nil
+}
+
+// Read reads the data after the preamble.
+func (c *ProxyProtoConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.reader == nil {
+		return c.Conn.Read(b)
+	}
+	if c.reader.Buffered() == 0 {
+		// drop the buffer once the bytes read along the preamble are consumed
+		c.reader = nil
+		return c.Conn.Read(b)
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address in the preamble, or the address of the peer if there is none.
+func (c *ProxyProtoConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && !c.header.Local {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address in the preamble, or the local address if there is none.
+func (c *ProxyProtoConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && !c.header.Local {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ProxyHeader returns the parsed preamble, nil if the peer did not send one or is not trusted.
+func (c *ProxyProtoConn) ProxyHeader() (*ProxyHeader, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *ProxyProtoConn) readHeader() {
+	if !c.trusted {
+		return
+	}
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		c.err = err
+		return
+	}
+	c.reader = bufio.NewReader(c.Conn)
+	c.header, c.err = readProxyHeader(c.reader)
+	if c.err != nil {
+		c.err = fmt.Errorf("invalid proxy protocol preamble: %w", c.err)
+		return
+	}
+	c.err = c.Conn.SetReadDeadline(time.Time{})
+}
+
+func readProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	// "PROXY" and the start of the v2 signature differ in the first byte, the rest is only peeked
+	// when the first byte matches so a client sending a short request is not waited on
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case proxyProtoV1Sig[0]:
+		if sig, err := r.Peek(len(proxyProtoV1Sig)); err != nil || !bytes.Equal(sig, proxyProtoV1Sig) {
+			return nil, err
+		}
+		return readProxyHeaderV1(r)
+	case proxyProtoV2Sig[0]:
+		if sig, err := r.Peek(len(proxyProtoV2Sig)); err != nil || !bytes.Equal(sig, proxyProtoV2Sig) {
+			return nil, err
+		}
+		return readProxyHeaderV2(r)
+	}
+	return nil, nil
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (*ProxyHeader, error) {
+	// the longest v1 line is 107 bytes including the CRLF
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("v1 line too long")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &ProxyHeader{Version: 1, Local: true}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 line %q", line)
+	}
+	src, err := parseProxyAddrV1(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyAddrV1(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyHeader{Version: 1, Source: src, Destination: dst}, nil
+}
+
+func parseProxyAddrV1(proto string, ip string, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || strings.Contains(ip, ":") != (proto == "TCP6") {
+		return nil, fmt.Errorf("invalid v1 address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (*ProxyHeader, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", head[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	res := &ProxyHeader{Version: 2}
+	switch head[12] & 0x0f {
+	case 0x0:
+		res.Local = true
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported command %d", head[12]&0x0f)
+	}
+	// the address block is followed by the TLVs, its size depends on the address family
+	size, block := 0, 0
+	switch head[13] >> 4 {
+	case 0x1:
+		size, block = 4, 12
+	case 0x2:
+		size, block = 16, 36
+	case 0x3:
+		block = 216
+	}
+	if len(body) < block {
+		return nil, fmt.Errorf("v2 address block too short")
+	}
+	var err error
+	if res.TLVs, err = parseProxyTLVs(body[block:]); err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		// unspecified and unix sockets carry no ip to report
+		res.Local = true
+	}
+	if res.Local {
+		return res, nil
+	}
+	src := net.IP(append([]byte{}, body[:size]...))
+	dst := net.IP(append([]byte{}, body[size:size*2]...))
+	srcPort := int(binary.BigEndian.Uint16(body[size*2:]))
+	dstPort := int(binary.BigEndian.Uint16(body[size*2+2:]))
+	if head[13]&0x0f == 0x2 {
+		res.Source = &net.UDPAddr{IP: src, Port: srcPort}
+		res.Destination = &net.UDPAddr{IP: dst, Port: dstPort}
+	} else {
+		res.Source = &net.TCPAddr{IP: src, Port: srcPort}
+		res.Destination = &net.TCPAddr{IP: dst, Port: dstPort}
+	}
+	return res, nil
+}
+
+// The v2 TLV types, see section 2.2 of the protocol.
+const (
+	PP2TypeALPN      byte = 0x01
+	PP2TypeAuthority byte = 0x02
+	PP2TypeCRC32C    byte = 0x03
+	PP2TypeNoop      byte = 0x04
+	PP2TypeUniqueID  byte = 0x05
+	PP2TypeSSL       byte = 0x20
+	PP2TypeNetNS     byte = 0x30
+
+	// PP2TypeAWS is the AWS NLB extension, subtype 0x01 carries the VPC endpoint id
+	// see https://docs.aws.amazon.com/elasticloadbalancing/latest/network/load-balancer-target-groups.html#proxy-protocol
+	PP2TypeAWS byte = 0xEA
+
+	// PP2TypeAzure is the Azure Private Link extension, subtype 0x01 carries the link id
+	// see https://learn.microsoft.com/en-us/azure/private-link/private-link-service-overview#getting-connection-information-using-tcp-proxy-v2
+	PP2TypeAzure byte = 0xEE
+)
+
+// The sub-types of the PP2TypeSSL TLV.
+const (
+	PP2SubtypeSSLVersion byte = 0x21
+	PP2SubtypeSSLCN      byte = 0x22
+	PP2SubtypeSSLCipher  byte = 0x23
+	PP2SubtypeSSLSigAlg  byte = 0x24
+	PP2SubtypeSSLKeyAlg  byte = 0x25
+)
+
+// ProxyTLV is a type-length-value extension of the PROXY protocol v2 preamble.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxySSL is the PP2TypeSSL TLV, the TLS information of the connection between the client and the proxy.
+type ProxySSL struct {
+	// Client is the bit field of PP2_CLIENT_SSL (0x01), PP2_CLIENT_CERT_CONN (0x02) and PP2_CLIENT_CERT_SESS (0x04).
+	Client byte
+
+	// Verified is true if the client presented a certificate and it was verified by the proxy.
+	Verified bool
+
+	// Version, CN, Cipher, SigAlg and KeyAlg are the sub-TLVs, empty if not sent.
+	Version string
+	CN      string
+	Cipher  string
+	SigAlg  string
+	KeyAlg  string
+}
+
+// TLV returns the value of the first TLV of the type, false if there is none. The accessors are safe to
+// call on a nil header.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.tlvs() {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ALPN returns the application protocol negotiated between the client and the proxy, e.g. "h2".
+func (h *ProxyHeader) ALPN() string {
+	v, _ := h.TLV(PP2TypeALPN)
+	return string(v)
+}
+
+// Authority returns the host name the client connected to, usually the TLS server name.
+func (h *ProxyHeader) Authority() string {
+	v, _ := h.TLV(PP2TypeAuthority)
+	return string(v)
+}
+
+// UniqueID returns the opaque connection id assigned by the proxy.
+func (h *ProxyHeader) UniqueID() []byte {
+	v, _ := h.TLV(PP2TypeUniqueID)
+	return v
+}
+
+// SSL returns the TLS information sent by the proxy, false if there is none or it is malformed.
+func (h *ProxyHeader) SSL() (ProxySSL, bool) {
+	v, ok := h.TLV(PP2TypeSSL)
+	if !ok || len(v) < 5 {
+		return ProxySSL{}, false
+	}
+	res := ProxySSL{
+		Client:   v[0],
+		Verified: v[0]&0x06 != 0 && binary.BigEndian.Uint32(v[1:5]) == 0,
+	}
+	subs, err := parseProxyTLVs(v[5:])
+	if err != nil {
+		return ProxySSL{}, false
+	}
+	for _, sub := range subs {
+		switch sub.Type {
+		case PP2SubtypeSSLVersion:
+			res.Version = string(sub.Value)
+		case PP2SubtypeSSLCN:
+			res.CN = string(sub.Value)
+		case PP2SubtypeSSLCipher:
+			res.Cipher = string(sub.Value)
+		case PP2SubtypeSSLSigAlg:
+			res.SigAlg = string(sub.Value)
+		case PP2SubtypeSSLKeyAlg:
+			res.KeyAlg = string(sub.Value)
+		}
+	}
+	return res, true
+}
+
+// AWSVPCEndpointID returns the VPC endpoint id sent by an AWS NLB behind a PrivateLink endpoint service.
+func (h *ProxyHeader) AWSVPCEndpointID() string {
+	for _, tlv := range h.tlvs() {
+		if tlv.Type == PP2TypeAWS && len(tlv.Value) > 0 && tlv.Value[0] == 0x01 {
+			return string(tlv.Value[1:])
+		}
+	}
+	return ""
+}
+
+// AzureLinkID returns the Private Link id sent by an Azure Private Link service, false if there is none.
+func (h *ProxyHeader) AzureLinkID() (uint32, bool) {
+	for _, tlv := range h.tlvs() {
+		if tlv.Type == PP2TypeAzure && len(tlv.Value) == 5 && tlv.Value[0] == 0x01 {
+			return binary.LittleEndian.Uint32(tlv.Value[1:]), true
+		}
+	}
+	return 0, false
+}
+
+func (h *ProxyHeader) tlvs() []ProxyTLV {
+	if h == nil {
+		return nil
+	}
+	return h.TLVs
+}
+
+// ProxyProtoConnContext stores the connections accepted by ProxyProtoListener in the context for
+// ProxyHeaderFromContext, it is meant to be used as http.Server.ConnContext and is a no-op for other
+// connections. ConnContext runs on the Accept loop, so the preamble is not read here but on the first use
+// from the goroutine serving the connection.
+func ProxyProtoConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	pc, ok := c.(*ProxyProtoConn)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, CtxKeyProxyHeader, pc)
+}
+
+// ProxyHeaderFromContext returns the ProxyHeader of the connection stored by ProxyProtoConnContext, nil if
+// there is none or the preamble is invalid. The preamble is read if it is not yet, which waits for the peer.
+func ProxyHeaderFromContext(ctx context.Context) *ProxyHeader {
+	pc, ok := ctx.Value(CtxKeyProxyHeader).(*ProxyProtoConn)
+	if !ok {
+		return nil
+	}
+	header, err := pc.ProxyHeader()
+	if err != nil {
+		return nil
+	}
+	return header
+}
+
+func parseProxyTLVs(b []byte) ([]ProxyTLV, error) {
+	var res []ProxyTLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, fmt.Errorf("v2 tlv too short")
+		}
+		size := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+size {
+			return nil, fmt.Errorf("v2 tlv 0x%02x too short", b[0])
+		}
+		res = append(res, ProxyTLV{Type: b[0], Value: b[3 : 3+size]})
+		b = b[3+size:]
+	}
+	return res, nil
+}
@@ -0,0 +1,59 @@
+package trustedproxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProxyProtoConnContextDoesNotBlockAccept(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &ProxyProtoListener{Listener: ln, Trusted: Loopback(), ReadHeaderTimeout: 5 * time.Second}
+	srv := &http.Server{
+		ConnContext: ProxyProtoConnContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := ProxyHeaderFromContext(r.Context())
+			if header == nil {
+				fmt.Fprint(w, "none")
+				return
+			}
+			fmt.Fprint(w, header.Source)
+		}),
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	// a trusted peer staying silent must not hold up the next connections
+	silent, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(c, "PROXY TCP4 203.0.113.7 192.0.2.1 4711 80\r\nGET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatalf("the second connection is not served: %v", err)
+	}
+	defer res.Body.Close()
+	body := make([]byte, 64)
+	n, _ := res.Body.Read(body)
+	if got := string(body[:n]); got != "203.0.113.7:4711" {
+		t.Errorf("proxy header source is %q, want 203.0.113.7:4711", got)
+	}
+}
@@ -0,0 +1,257 @@
+package trustedproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRemoteRefresh is the refresh interval used by RemoteWhitelist.Run when Interval is not positive.
+	DefaultRemoteRefresh = time.Hour
+
+	// MaxRemoteWhitelistSize is the maximum size of a whitelist fetched by RemoteWhitelist.
+	MaxRemoteWhitelistSize = 4 << 20
+)
+
+// RemoteWhitelist fetches the trusted networks from a URL, e.g. the ranges published by an edge provider, and
+// swaps them into the active extractor without a restart. The whitelist trusts nobody until the first refresh
+// or Set, and a failed or empty fetch keeps the last known networks.
+type RemoteWhitelist struct {
+	// URLs is the mirrors of the list, tried in order until one of them succeeds.
+	URLs []string
+
+	// Interval is how often Run refreshes the list, DefaultRemoteRefresh is used if it is not positive.
+	Interval time.Duration
+
+	// Client fetches the list, http.DefaultClient is used if it is nil.
+	Client *http.Client
+
+	// Parse parses the fetched body, ParseRemoteWhitelist is used if it is nil.
+	Parse func(body []byte) (*CIDRWhitelist, error)
+
+	// Extractor builds the active extractor from the fetched whitelist, the whitelist itself is used if it is
+	// nil, e.g. set it to wrap the whitelist into a DepthLimitedWhitelist.
+	Extractor func(whitelist *CIDRWhitelist) IPExtractor
+
+	// OnError is invoked with the mirror failing during Run, the error is dropped if it is nil.
+	OnError func(url string, err error)
+
+	// Budget bounds the memory of the fetched whitelist, a fetched list not fitting is rejected and the last
+	// known networks are kept. The whitelists given to Set are always accounted for.
+	Budget *MemoryBudget
+
+	mu        sync.RWMutex
+	whitelist *CIDRWhitelist
+	active    IPExtractor
+	cost      int64
+}
+
+// NewRemoteWhitelist returns a whitelist fetched from the mirrors, call Refresh or Run before use.
+func NewRemoteWhitelist(urls ...string) *RemoteWhitelist {
+	return &RemoteWhitelist{URLs: append([]string{}, urls...)}
+}
+
+// RemoteWhitelistKeys is the keys of a JSON object read by ParseRemoteWhitelist, the other keys are ignored.
+var RemoteWhitelistKeys = []string{"cidrs", "ipv4_cidrs", "ipv6_cidrs", "addresses", "ipv6_addresses"}
+
+// ParseRemoteWhitelist parses either the comma or whitespace separated networks accepted by
+// CIDRWhitelist.UnmarshalText, a JSON array of networks, or a JSON object with arrays of networks under
+// RemoteWhitelistKeys, e.g. {"ipv4_cidrs": ["192.0.2.0/24"]}. Only these schemas are read so a changed
+// document cannot widen the trust, every entry of them must be a network or an ip and an object without any
+// of the keys is rejected. Set RemoteWhitelist.Parse for other formats.
+func ParseRemoteWhitelist(body []byte) (*CIDRWhitelist, error) {
+	body = bytes.TrimSpace(body)
+	res := &CIDRWhitelist{}
+	if len(body) == 0 || (body[0] != '[' && body[0] != '{') {
+		if err := res.UnmarshalText(body); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	if body[0] == '[' {
+		var cidrs []string
+		if err := json.Unmarshal(body, &cidrs); err != nil {
+			return nil, fmt.Errorf("invalid whitelist json: %w", err)
+		}
+		return NewCIDRWhitelist(cidrs...)
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid whitelist json: %w", err)
+	}
+	var cidrs []string
+	found := false
+	for _, key := range RemoteWhitelistKeys {
+		raw, ok := doc[key]
+		if !ok {
+			continue
+		}
+		var list []string
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("invalid whitelist json %q: %w", key, err)
+		}
+		found = true
+		cidrs = append(cidrs, list...)
+	}
+	if !found {
+		return nil, fmt.Errorf("whitelist json has none of the keys %v", RemoteWhitelistKeys)
+	}
+	return NewCIDRWhitelist(cidrs...)
+}
+
+// Whitelist returns the last fetched whitelist, nil before the first successful refresh.
+func (w *RemoteWhitelist) Whitelist() *CIDRWhitelist {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.whitelist
+}
+
+// Refresh fetches the list from the first working mirror, the error of the last mirror is returned if
+// every mirror fails.
+func (w *RemoteWhitelist) Refresh(ctx context.Context) error {
+	return w.refresh(ctx, nil)
+}
+
+// Run refreshes the list immediately and then every Interval until the context is done.
+func (w *RemoteWhitelist) Run(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultRemoteRefresh
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_ = w.refresh(ctx, w.OnError)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Set swaps the whitelist into the active extractor, e.g. to seed it with a built-in list before the first
+// refresh. A nil whitelist is rejected.
+func (w *RemoteWhitelist) Set(whitelist *CIDRWhitelist) error {
+	if whitelist == nil {
+		return fmt.Errorf("nil whitelist")
+	}
+	cost := whitelistCost(whitelist)
+	w.Budget.force(cost)
+	w.swap(whitelist, cost)
+	return nil
+}
+
+func (w *RemoteWhitelist) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return w.ResolveRequest(nil, &net.TCPAddr{IP: remote}, forwarded)
+}
+
+func (w *RemoteWhitelist) ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	w.mu.RLock()
+	active := w.active
+	w.mu.RUnlock()
+	if active == nil {
+		return nil, remote.IP, forwarded, nil
+	}
+	return resolveRequest(active, r, remote, forwarded)
+}
+
+func (w *RemoteWhitelist) refresh(ctx context.Context, onError func(url string, err error)) error {
+	err := fmt.Errorf("no whitelist url")
+	for _, u := range w.URLs {
+		var whitelist *CIDRWhitelist
+		if whitelist, err = w.fetch(ctx, u); err != nil {
+			if onError != nil {
+				onError(u, err)
+			}
+			continue
+		}
+		// the old list is still held while the new one is built, so both must fit
+		cost := whitelistCost(whitelist)
+		if !w.Budget.Reserve(cost) {
+			err = fmt.Errorf("whitelist of %d bytes exceeds the memory budget", cost)
+			if onError != nil {
+				onError(u, err)
+			}
+			return err
+		}
+		w.swap(whitelist, cost)
+		return nil
+	}
+	return err
+}
+
+// swap makes the whitelist active and releases the cost of the previous one
+func (w *RemoteWhitelist) swap(whitelist *CIDRWhitelist, cost int64) {
+	var active IPExtractor = whitelist
+	if w.Extractor != nil {
+		active = w.Extractor(whitelist)
+	}
+	w.mu.Lock()
+	old := w.cost
+	w.whitelist = whitelist
+	w.active = active
+	w.cost = cost
+	w.mu.Unlock()
+	w.Budget.Release(old)
+}
+
+func (w *RemoteWhitelist) fetch(ctx context.Context, url string) (*CIDRWhitelist, error) {
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxRemoteWhitelistSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxRemoteWhitelistSize {
+		return nil, fmt.Errorf("whitelist larger than %d bytes", MaxRemoteWhitelistSize)
+	}
+	parse := w.Parse
+	if parse == nil {
+		parse = ParseRemoteWhitelist
+	}
+	whitelist, err := parse(body)
+	if err != nil {
+		return nil, err
+	}
+	// an empty list is more likely a broken mirror than the intent to trust nobody
+	if whitelist == nil || len(whitelist.Whitelist) == 0 {
+		return nil, fmt.Errorf("empty whitelist")
+	}
+	return whitelist, nil
+}
+
+// whitelistCost is the cost of the networks of the whitelist
+func whitelistCost(w *CIDRWhitelist) int64 {
+	if w == nil {
+		return 0
+	}
+	var res int64
+	for _, nets := range [][]*net.IPNet{w.Whitelist, w.Exclude} {
+		for _, n := range nets {
+			res += int64(len(n.IP)+len(n.Mask)) + entryOverhead
+		}
+	}
+	return res
+}
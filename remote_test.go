@@ -0,0 +1,69 @@
+package trustedproxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseRemoteWhitelist(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{"text", "192.0.2.0/24, 2001:db8::/32\n198.51.100.1", []string{"192.0.2.0/24", "2001:db8::/32", "198.51.100.1/32"}, false},
+		{"string array", `["192.0.2.0/24", "2001:db8::/32"]`, []string{"192.0.2.0/24", "2001:db8::/32"}, false},
+		{"named keys", `{"ipv4_cidrs": ["192.0.2.0/24"], "ipv6_cidrs": ["2001:db8::/32"]}`, []string{"192.0.2.0/24", "2001:db8::/32"}, false},
+		{"fastly keys", `{"addresses": ["192.0.2.0/24"], "ipv6_addresses": ["2001:db8::/32"]}`, []string{"192.0.2.0/24", "2001:db8::/32"}, false},
+		{"other fields are not trusted", `{"cidrs": ["192.0.2.0/24"], "error": "blocked 0.0.0.0/0", "origin": "8.8.8.8"}`, []string{"192.0.2.0/24"}, false},
+		{"nested envelope", `{"result": {"ipv4_cidrs": ["192.0.2.0/24"]}}`, nil, true},
+		{"invalid entry", `["192.0.2.0/24", "not a network"]`, nil, true},
+		{"not strings", `[1, 2]`, nil, true},
+		{"named key not an array", `{"cidrs": "192.0.2.0/24"}`, nil, true},
+		{"invalid json", `{"cidrs": [`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRemoteWhitelist([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsed %v, want an error", got.Whitelist)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var nets []string
+			for _, n := range got.Whitelist {
+				nets = append(nets, n.String())
+			}
+			if len(nets) != len(tt.want) {
+				t.Fatalf("parsed %v, want %v", nets, tt.want)
+			}
+			for i := range nets {
+				if nets[i] != tt.want[i] {
+					t.Errorf("parsed %v, want %v", nets, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteWhitelistSetNil(t *testing.T) {
+	w := NewRemoteWhitelist()
+	if err := w.Set(nil); err == nil {
+		t.Error("nil whitelist is accepted")
+	}
+	w.Extractor = func(whitelist *CIDRWhitelist) IPExtractor {
+		return CloudflareConnectingIP(whitelist)
+	}
+	if err := w.Set(nil); err == nil {
+		t.Error("nil whitelist is accepted")
+	}
+	proxy, remote, _, err := w.Resolve(net.ParseIP("192.0.2.1"), nil)
+	if err != nil || proxy != nil || !remote.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("resolved %v %v %v before any whitelist", proxy, remote, err)
+	}
+}
@@ -0,0 +1,95 @@
+package trustedproxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultReplayCacheSize is the size used by NewReplayCache when the given size is not positive.
+const DefaultReplayCacheSize = 65536
+
+var (
+	// ErrReplayed is returned by ReplayCache.Check for a nonce seen before.
+	ErrReplayed = errors.New("forward signature replayed")
+
+	// ErrReplayCacheFull is returned by ReplayCache.Check when every entry is still live, the request is
+	// rejected rather than forgetting a nonce which could then be replayed.
+	ErrReplayCacheFull = errors.New("replay cache full")
+)
+
+// ReplayCache remembers the nonces of the signed requests until their signatures expire, so a captured
+// request cannot be replayed within the accepted clock skew. The size must cover the signed request rate
+// over twice the max skew of the verifier, the cache fails closed once it is full.
+type ReplayCache struct {
+	size int
+
+	// Budget bounds the memory of the nonces, the cache fails closed when it is exhausted like when it is
+	// full. It must be set before the cache is used.
+	Budget *MemoryBudget
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+	order   []replayEntry
+}
+
+type replayEntry struct {
+	key   string
+	until time.Time
+}
+
+// NewReplayCache returns a ReplayCache holding at most size nonces.
+func NewReplayCache(size int) *ReplayCache {
+	if size <= 0 {
+		size = DefaultReplayCacheSize
+	}
+	return &ReplayCache{
+		size:    size,
+		entries: make(map[string]time.Time, size),
+	}
+}
+
+// Check records the nonce until the time, ErrReplayed is returned if it is already recorded.
+func (c *ReplayCache) Check(nonce string, until time.Time) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(now)
+	if exp, ok := c.entries[nonce]; ok && now.Before(exp) {
+		return ErrReplayed
+	}
+	if len(c.entries) >= c.size || !c.Budget.Reserve(replayEntryCost(nonce)) {
+		return ErrReplayCacheFull
+	}
+	c.entries[nonce] = until
+	c.order = append(c.order, replayEntry{nonce, until})
+	return nil
+}
+
+// Len returns the number of the live nonces.
+func (c *ReplayCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(time.Now())
+	return len(c.entries)
+}
+
+// expire drops the expired nonces, the order is by insertion so an entry outliving the ones after it only
+// delays their removal
+func (c *ReplayCache) expire(now time.Time) {
+	i := 0
+	for ; i < len(c.order) && !now.Before(c.order[i].until); i++ {
+		if exp, ok := c.entries[c.order[i].key]; ok && exp.Equal(c.order[i].until) {
+			delete(c.entries, c.order[i].key)
+		}
+		c.Budget.Release(replayEntryCost(c.order[i].key))
+	}
+	if i > 0 {
+		c.order = append(c.order[:0], c.order[i:]...)
+	}
+}
+
+// replayEntryCost is the cost of a nonce, which is held by both the map and the order
+func replayEntryCost(nonce string) int64 {
+	return int64(2*len(nonce)) + 2*entryOverhead
+}
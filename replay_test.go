@@ -0,0 +1,92 @@
+package trustedproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReplayCache(t *testing.T) {
+	live := time.Now().Add(time.Minute)
+	c := NewReplayCache(1)
+	if err := c.Check("a", live); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Check("a", live); !errors.Is(err, ErrReplayed) {
+		t.Errorf("replayed nonce returned %v", err)
+	}
+	if err := c.Check("b", live); !errors.Is(err, ErrReplayCacheFull) {
+		t.Errorf("full cache returned %v", err)
+	}
+
+	c = NewReplayCache(1)
+	if err := c.Check("a", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Check("b", live); err != nil {
+		t.Errorf("expired nonce is not dropped: %v", err)
+	}
+	if c.Len() != 1 {
+		t.Errorf("%d live nonces, want 1", c.Len())
+	}
+}
+
+func TestReplayCacheBudget(t *testing.T) {
+	c := NewReplayCache(16)
+	c.Budget = NewMemoryBudget(replayEntryCost("a"))
+	live := time.Now().Add(time.Minute)
+	if err := c.Check("a", live); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Check("b", live); !errors.Is(err, ErrReplayCacheFull) {
+		t.Errorf("exhausted budget returned %v", err)
+	}
+}
+
+func TestHMACVerifierReplay(t *testing.T) {
+	key := []byte("secret")
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		return r
+	}
+	v := &HMACVerifier{Keys: map[string][]byte{"k1": key}, Replay: NewReplayCache(16)}
+
+	signed := newRequest()
+	(&HMACSigner{KeyID: "k1", Key: key, Nonce: true}).Sign(signed)
+	if err := v.Verify(signed); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(signed); !errors.Is(err, ErrReplayed) {
+		t.Errorf("replayed signature returned %v", err)
+	}
+
+	tampered := newRequest()
+	(&HMACSigner{KeyID: "k1", Key: key, Nonce: true}).Sign(tampered)
+	tampered.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if err := v.Verify(tampered); err == nil {
+		t.Error("tampered request verified")
+	}
+	// the forged request did not record its nonce
+	tampered.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if err := v.Verify(tampered); err != nil {
+		t.Errorf("the nonce of a forged request is recorded: %v", err)
+	}
+
+	unsigned := newRequest()
+	(&HMACSigner{KeyID: "k1", Key: key}).Sign(unsigned)
+	if err := v.Verify(unsigned); err == nil {
+		t.Error("signature without a nonce verified")
+	}
+
+	forged := newRequest()
+	(&HMACSigner{KeyID: "k1", Key: []byte("other"), Nonce: true}).Sign(forged)
+	if err := v.Verify(forged); err == nil {
+		t.Error("signature with another key verified")
+	}
+	if n := v.Replay.Len(); n != 2 {
+		t.Errorf("%d nonces recorded, want 2", n)
+	}
+}
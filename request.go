@@ -4,7 +4,11 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ForwardedRequest is an interface that extends http.Request with methods to
@@ -30,9 +34,19 @@ type ForwardedRequest interface {
 	GetTrustedRemoteAddr() net.IP
 
 	// GetTrustedForwardedFor returns the trusted forwarded for of the request.
-	GetTrustedForwardedFor() []net.IP
+	GetTrustedForwardedFor() Chain
 
-	// GetTrustedURL returns the trusted URL of the request.
+	// GetTrustedPort returns the trusted port of the request, the default port of the trusted
+	// protocol is returned if the port is not known.
+	GetTrustedPort() int
+
+	// GetTrustedPrefix returns the path prefix the application is mounted under by the trusted proxy,
+	// e.g. "/api", "" is returned if there is no prefix.
+	GetTrustedPrefix() string
+
+	// GetTrustedURL returns the trusted URL of the request, its host includes the trusted port if
+	// it is not the default port of the trusted protocol, and its path includes the trusted prefix
+	// so it can be used to generate links. The URL of the trusted request does not include the prefix.
 	GetTrustedURL() *url.URL
 
 	// GetTrustedRequest returns the trusted request of the request.
@@ -41,152 +55,505 @@ type ForwardedRequest interface {
 	// BuildRequestForForward returns a copy of the request with proper X-Forwarded-* headers
 	// set for forwarding to the next server.
 	// stripForwardedIPs will keep the only trusted remote address in X-Forwarded-For.
+	// The ForwardHook of the HTTPHandler is invoked on the copy before it is returned.
 	BuildRequestForForward(stripForwardedIPs bool) *http.Request
+
+	// BuildRequestForForwardWith is BuildRequestForForward with more control over the headers set,
+	// e.g. to emit the RFC 7239 Forwarded header.
+	BuildRequestForForwardWith(opts ForwardOptions) *http.Request
+
+	// GetClientCertInfo returns the client certificates forwarded by the trusted proxy.
+	// nil is returned if the request is not coming from a trusted proxy.
+	GetClientCertInfo() ([]ClientCertInfo, error)
+
+	// GetRawForwardedChain returns every entry of the forwarded chain header as received, including the
+	// "unknown" and obfuscated entries which are not part of the resolved chain, for audit purposes.
+	GetRawForwardedChain() []Hop
+
+	// GetHopTrace returns the explanation trace of the request, the verdict of every entry of the raw
+	// forwarded chain from the left, with the split entries of TolerantParsing as hops of their own, followed
+	// by the immediate peer. It shows why each hop was trusted, skipped or treated as unknown by UnknownHops.
+	GetHopTrace() []HopDecision
+
+	// GetEnrichment returns the information of the trusted remote address looked up by the Enricher of the
+	// HTTPHandler, the lookup is done once on the first call. nil is returned if there is no Enricher. A panic
+	// of the Enricher is recovered, reported to OnHookError and returned as a *HookError, the request is not
+	// failed even with HookErrorsFatal since the lookup runs in the downstream handler.
+	GetEnrichment() (any, error)
+
+	// GetResult returns a snapshot of the trusted values of the request.
+	GetResult() Result
+
+	// GetTLSPosture returns the effective TLS state from the client to this server, so security
+	// middleware can require https end-to-end rather than only a trusted proto.
+	GetTLSPosture() TLSPosture
+
+	// GetOriginalForwardHeaders returns a copy of the forwarding related headers as received,
+	// before any of them is removed or rewritten by the middleware.
+	GetOriginalForwardHeaders() http.Header
+
+	// GetProxyHeader returns the PROXY protocol preamble of the connection, nil if the connection did
+	// not send one or ProxyProtoConnContext is not set as the http.Server.ConnContext.
+	GetProxyHeader() *ProxyHeader
+
+	// GetClientHello returns the TLS ClientHello of the connection, nil if the connection is not TLS or
+	// ClientHelloRecorder is not set up on the server.
+	GetClientHello() *ClientHello
+
+	// Cookie, Cookies, UserAgent and Referer are the accessors of http.Request evaluated against the
+	// trusted request, so handlers do not need to reach back into GetTrustedRequest for them.
+	Cookie(name string) (*http.Cookie, error)
+	Cookies() []*http.Cookie
+	UserAgent() string
+	Referer() string
 }
 
 type forwardedRequest struct {
+	// enrichNanos is the duration of the enrichment, accessed atomically and kept first for the 64-bit alignment
+	enrichNanos int64
+
 	*http.Request
 
+	// handler is the handler which resolved the request, nil if the values are overridden
+	handler *HTTPHandler
+
 	proxyIP net.IP
 
 	trustedHost  string
 	trustedProto string
+	trustedPort  int
+
+	// forwardedProto is the proto before NoProtoDowngrade pins it, the protocol of the client leg
+	forwardedProto string
+
+	// hostPort is the port part of the trusted host
+	hostPort hostPort
+
+	trustedPrefix string
 
 	trustedRemoteAddr   net.IP
 	trustedForwardedFor []net.IP
 
+	// unknownRemote is true if the remote is an undisclosed hop, the ones of trustedForwardedFor are nil
+	unknownRemote  bool
+	trustedProxies []net.IP
+
+	// peerPort is the source port of the immediate peer, 0 if unknown
+	peerPort int
+
 	trustedURL *url.URL
 
+	// requestURL is the trusted url without the prefix, used by the trusted and forwarded requests
+	requestURL *url.URL
+
+	degraded bool
+	timings  StageTimings
+
+	originalHeaders http.Header
+	rawChain        []Hop
+
+	// sourcedChain is the chain supplied by HTTPHandler.ChainSource, nil if it is read from the headers
+	sourcedChain []net.IP
+
+	enrichOnce  sync.Once
+	enrichment  any
+	enrichError error
+
+	trustedOnce    sync.Once
 	trustedRequest *http.Request
 }
 
-func (f *forwardedRequest) GetOriginalRequest() *http.Request {
-	return f.Request
+// init computes the trusted values from the resolved ips, the values are never modified
+// afterwards so the forwarded request can be shared between goroutines.
+func (f *forwardedRequest) init() {
+	if f.trustedHost == "" {
+		f.trustedHost = f.resolveHost()
+	}
+	if f.trustedProto == "" {
+		f.forwardedProto = f.resolveForwardedProto()
+		f.trustedProto = f.resolveProto(f.forwardedProto)
+	} else if f.forwardedProto == "" {
+		f.forwardedProto = f.trustedProto
+	}
+	u := *f.URL
+	if f.handler != nil && f.handler.HostCache != nil {
+		e := f.handler.HostCache.entry(f.trustedHost, f.trustedProto)
+		f.trustedHost = e.host
+		f.hostPort = e.port
+		u.Host, u.Scheme = e.url.Host, e.url.Scheme
+	} else {
+		f.hostPort = splitHostPort(f.trustedHost)
+		u.Host = f.trustedHost
+		u.Scheme = f.trustedProto
+	}
+	f.rawChain = f.resolveRawChain()
+	if f.trustedPort == 0 {
+		f.trustedPort = f.resolvePort()
+	}
+	if !f.hostPort.has && f.trustedPort != defaultPort(f.trustedProto) {
+		u.Host = net.JoinHostPort(strings.Trim(u.Host, "[]"), strconv.Itoa(f.trustedPort))
+	}
+	f.requestURL = &u
+	f.trustedPrefix = f.resolvePrefix()
+	t := u
+	if f.trustedPrefix != "" {
+		t.Path = f.trustedPrefix + u.Path
+		t.RawPath = ""
+	}
+	f.trustedURL = &t
 }
 
-func (f *forwardedRequest) IsBehindProxy() bool {
-	return f.proxyIP != nil
+func (f *forwardedRequest) resolvePrefix() string {
+	if f.proxyIP == nil {
+		return ""
+	}
+	prefix, _, _ := strings.Cut(f.Header.Get(f.handler.headerConfig().Prefix), ",")
+	prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+	// only accept an absolute path, anything else could turn the links into another origin
+	if !strings.HasPrefix(prefix, "/") || strings.HasPrefix(prefix, "//") || strings.ContainsAny(prefix, "?#\\") {
+		return ""
+	}
+	return prefix
 }
 
-func (f *forwardedRequest) GetProxyIP() net.IP {
-	return f.proxyIP
+func (f *forwardedRequest) resolveRawChain() []Hop {
+	if f.sourcedChain != nil {
+		res := make([]Hop, len(f.sourcedChain))
+		for i, ip := range f.sourcedChain {
+			res[i] = Hop{IP: ip}
+			if ip == nil {
+				res[i] = Hop{Obfuscated: "unknown"}
+			}
+		}
+		return res
+	}
+	if f.headerMode() != HeaderModeForwarded {
+		return parseRawChain(f.Header.Values(f.handler.headerConfig().ForwardedFor))
+	}
+	elements, _ := ParseForwarded(f.Header.Values("Forwarded"))
+	res := make([]Hop, 0, len(elements))
+	for _, e := range elements {
+		res = append(res, parseHopEntry(e.For))
+	}
+	return res
 }
 
-func (f *forwardedRequest) GetTrustedHost() string {
-	if f.trustedHost != "" {
-		return f.trustedHost
+func (f *forwardedRequest) resolvePort() int {
+	if f.proxyIP != nil {
+		// an absent header is the common case, skip the error Atoi allocates for it
+		if v := strings.TrimSpace(f.forwardHeaderValue(f.handler.headerConfig().Port)); v != "" {
+			if port, err := strconv.Atoi(v); err == nil && port > 0 && port <= 65535 {
+				return port
+			}
+		}
+	}
+	if f.hostPort.has && f.hostPort.valid {
+		return f.hostPort.port
+	}
+	return defaultPort(f.trustedProto)
+}
+
+// forwardHeaderValue returns the value of the X-Forwarded host, proto or port header, the first line as a
+// whole unless RightmostValues is set
+func (f *forwardedRequest) forwardHeaderValue(name string) string {
+	if f.handler != nil && f.handler.RightmostValues {
+		return lastHeaderValue(f.Header, name)
+	}
+	return f.Header.Get(name)
+}
+
+// hostPort is the port part of a host, split once per distinct host when the HostCache is used
+type hostPort struct {
+	// has is true if the host has a port part, valid if it is a number
+	has   bool
+	valid bool
+	port  int
+}
+
+func splitHostPort(host string) hostPort {
+	_, p, err := net.SplitHostPort(host)
+	if err != nil {
+		return hostPort{}
+	}
+	port, err := strconv.Atoi(p)
+	return hostPort{has: true, valid: err == nil, port: port}
+}
+
+func defaultPort(proto string) int {
+	if proto == "https" {
+		return 443
 	}
+	return 80
+}
+
+func (f *forwardedRequest) resolveHost() string {
 	if f.proxyIP == nil {
-		f.trustedHost = f.Host
-		return f.trustedHost
+		return f.Host
+	}
+	xHost := f.forwardHeaderValue(f.handler.headerConfig().Host)
+	if f.headerMode() == HeaderModeForwarded {
+		xHost = lastForwardedValue(f.Header, func(e ForwardedElement) string { return e.Host })
 	}
-	xHost := f.Header.Get("X-Forwarded-Host")
 	if xHost != "" {
-		f.trustedHost = xHost
-		return f.trustedHost
+		return xHost
 	}
-	f.trustedHost = f.Host
-	return f.trustedHost
+	return f.Host
 }
 
-func (f *forwardedRequest) GetTrustedProto() string {
-	if f.trustedProto != "" {
-		return f.trustedProto
+// resolveProto returns the trusted proto of the forwarded proto, pinned to "https" by NoProtoDowngrade
+func (f *forwardedRequest) resolveProto(proto string) string {
+	if proto == "http" && f.TLS != nil && f.handler != nil && f.handler.NoProtoDowngrade {
+		return "https"
 	}
+	return proto
+}
+
+func (f *forwardedRequest) resolveForwardedProto() string {
 	if f.proxyIP == nil {
 		if f.TLS != nil {
-			f.trustedProto = "https"
-		} else {
-			f.trustedProto = "http"
+			return "https"
 		}
-		return f.trustedProto
+		return "http"
+	}
+	xProto := f.forwardHeaderValue(f.handler.headerConfig().Proto)
+	if f.headerMode() == HeaderModeForwarded {
+		xProto = lastForwardedValue(f.Header, func(e ForwardedElement) string { return e.Proto })
 	}
-	xProto := f.Header.Get("X-Forwarded-Proto")
 
 	// some proxy will pass "ws" or "wss" as X-Forwarded-Proto which is not a standard value,
 	// so we will convert it to "http" or "https" respectively, any other value will be ignored.
 	// see https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Forwarded-Proto
 	switch strings.ToLower(xProto) {
 	case "http", "ws":
-		f.trustedProto = "http"
-		return f.trustedProto
+		return "http"
 	case "https", "wss":
-		f.trustedProto = "https"
-		return f.trustedProto
+		return "https"
+	}
+	for _, name := range f.handler.headerConfig().SSL {
+		if strings.EqualFold(strings.TrimSpace(f.Header.Get(name)), "on") {
+			return "https"
+		}
 	}
 	if f.TLS != nil {
-		f.trustedProto = "https"
-	} else {
-		f.trustedProto = "http"
+		return "https"
 	}
+	return "http"
+}
+
+func (f *forwardedRequest) headerMode() HeaderMode {
+	return f.handler.headerFamily(f.Request)
+}
+
+func (f *forwardedRequest) GetOriginalRequest() *http.Request {
+	return f.Request
+}
+
+func (f *forwardedRequest) IsBehindProxy() bool {
+	return f.proxyIP != nil
+}
+
+func (f *forwardedRequest) GetProxyIP() net.IP {
+	return cloneIP(f.proxyIP)
+}
+
+func (f *forwardedRequest) GetTrustedHost() string {
+	return f.trustedHost
+}
+
+func (f *forwardedRequest) GetTrustedProto() string {
 	return f.trustedProto
 }
 
+func (f *forwardedRequest) GetTrustedPort() int {
+	return f.trustedPort
+}
+
 func (f *forwardedRequest) GetTrustedRemoteAddr() net.IP {
-	return f.trustedRemoteAddr
+	return cloneIP(f.trustedRemoteAddr)
+}
+
+func (f *forwardedRequest) GetTrustedForwardedFor() Chain {
+	return cloneIPs(withUnknownHopIP(f.trustedForwardedFor))
 }
 
-func (f *forwardedRequest) GetTrustedForwardedFor() []net.IP {
-	return f.trustedForwardedFor
+func (f *forwardedRequest) GetTrustedPrefix() string {
+	return f.trustedPrefix
 }
 
 func (f *forwardedRequest) GetTrustedURL() *url.URL {
-	if f.trustedURL != nil {
-		return f.trustedURL
-	}
-	u, _ := url.Parse(f.URL.String())
-	u.Host = f.GetTrustedHost()
-	u.Scheme = f.GetTrustedProto()
-	f.trustedURL = u
-	return f.trustedURL
+	// clone the url to avoid modifying the shared url
+	u := *f.trustedURL
+	return &u
 }
 
 func (f *forwardedRequest) GetTrustedRequest() *http.Request {
-	if f.trustedRequest != nil {
-		return f.trustedRequest
-	}
-	f.trustedRequest = f.Request.Clone(f.Context())
-	f.trustedRequest.Host = f.GetTrustedHost()
-	f.trustedRequest.URL = f.GetTrustedURL()
-	f.trustedRequest.RemoteAddr = f.GetTrustedRemoteAddr().String()
+	f.trustedOnce.Do(func() {
+		f.trustedRequest = f.Request.Clone(f.Context())
+		f.trustedRequest.Host = f.trustedHost
+		u := *f.requestURL
+		f.trustedRequest.URL = &u
+		f.trustedRequest.RemoteAddr = f.trustedRemoteAddr.String()
+
+		headers := f.handler.headerConfig()
+		if len(f.trustedForwardedFor) > 0 {
+			if headers.ForwardedFor != "" {
+				f.trustedRequest.Header.Set(headers.ForwardedFor, hopNode(f.trustedForwardedFor[0]))
+			}
+		} else {
+			for _, name := range headers.names() {
+				f.trustedRequest.Header.Del(name)
+			}
+			f.trustedRequest.Header.Del("Forwarded")
+		}
+	})
+	return f.trustedRequest
+}
 
-	if len(f.trustedForwardedFor) > 0 {
-		f.trustedRequest.Header.Set("X-Forwarded-For", f.trustedForwardedFor[0].String())
-	} else {
-		f.trustedRequest.Header.Del("X-Forwarded-For")
-		f.trustedRequest.Header.Del("X-Forwarded-Host")
-		f.trustedRequest.Header.Del("X-Forwarded-Proto")
+func (f *forwardedRequest) GetResult() Result {
+	return Result{
+		ProxyIP:      f.GetProxyIP(),
+		RemoteAddr:   f.GetTrustedRemoteAddr(),
+		ForwardedFor: f.GetTrustedForwardedFor(),
+		Proxies:      cloneIPs(f.trustedProxies),
+		PeerPort:     f.peerPort,
+		Host:         f.trustedHost,
+		Proto:        f.trustedProto,
+		Port:         f.trustedPort,
+		Degraded:     f.degraded,
+		Timings:      f.stageTimings(),
 	}
+}
 
-	return f.trustedRequest
+func (f *forwardedRequest) stageTimings() StageTimings {
+	res := f.timings
+	res.Enrich = time.Duration(atomic.LoadInt64(&f.enrichNanos))
+	return res
 }
 
 func (f *forwardedRequest) BuildRequestForForward(stripForwardedIPs bool) *http.Request {
-	req := f.Clone(f.Context())
-	req.Host = f.GetTrustedHost()
+	return f.BuildRequestForForwardWith(ForwardOptions{StripForwardedIPs: stripForwardedIPs})
+}
 
-	// clone the url to avoid modifying the original request url
-	u := *f.GetTrustedURL()
-	req.URL = &u
+func (f *forwardedRequest) GetClientCertInfo() ([]ClientCertInfo, error) {
+	if f.proxyIP == nil {
+		return nil, nil
+	}
+	return ExtractClientCertInfo(&f.Header)
+}
 
-	req.Header.Del("X-Forwarded-For")
-	req.Header.Del("X-Forwarded-Host")
-	req.Header.Del("X-Forwarded-Proto")
-	req.Header.Del("X-Real-IP")
+func cloneIP(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+	return append(net.IP{}, ip...)
+}
+
+func cloneIPs(ips []net.IP) []net.IP {
+	if ips == nil {
+		return nil
+	}
+	res := make([]net.IP, len(ips))
+	for i, ip := range ips {
+		res[i] = cloneIP(ip)
+	}
+	return res
+}
 
-	var ips []string
+func (f *forwardedRequest) GetRawForwardedChain() []Hop {
+	res := make([]Hop, len(f.rawChain))
+	for i, h := range f.rawChain {
+		res[i] = Hop{IP: cloneIP(h.IP), Port: h.Port, Obfuscated: h.Obfuscated}
+	}
+	return res
+}
 
-	if !stripForwardedIPs {
-		for _, ip := range f.GetTrustedForwardedFor() {
-			ips = append(ips, ip.String())
+func (f *forwardedRequest) GetEnrichment() (any, error) {
+	f.enrichOnce.Do(func() {
+		if f.handler != nil && f.handler.Enricher != nil {
+			start := f.handler.startStage()
+			// only a panic is a hook error, the lookup errors are returned as they are
+			var value any
+			var lookupErr error
+			if err := f.handler.runHook("Enricher", func() error {
+				value, lookupErr = f.handler.Enricher.Enrich(f.Context(), cloneIP(f.trustedRemoteAddr))
+				return nil
+			}); err != nil {
+				value, lookupErr = nil, err
+				f.handler.reportHookError(err)
+			}
+			f.enrichment, f.enrichError = value, lookupErr
+			atomic.StoreInt64(&f.enrichNanos, int64(f.handler.endStage(MetricStageEnrich, start)))
 		}
+	})
+	return f.enrichment, f.enrichError
+}
+
+func (f *forwardedRequest) GetOriginalForwardHeaders() http.Header {
+	return f.originalHeaders.Clone()
+}
+
+func (f *forwardedRequest) GetProxyHeader() *ProxyHeader {
+	return ProxyHeaderFromContext(f.Context())
+}
+
+func (f *forwardedRequest) GetClientHello() *ClientHello {
+	slot, _ := f.Context().Value(CtxKeyClientHello).(*clientHelloSlot)
+	if slot == nil {
+		return nil
 	}
+	return slot.hello
+}
+
+func (f *forwardedRequest) Cookie(name string) (*http.Cookie, error) {
+	return f.GetTrustedRequest().Cookie(name)
+}
+
+func (f *forwardedRequest) Cookies() []*http.Cookie {
+	return f.GetTrustedRequest().Cookies()
+}
+
+func (f *forwardedRequest) UserAgent() string {
+	return f.GetTrustedRequest().UserAgent()
+}
+
+func (f *forwardedRequest) Referer() string {
+	return f.GetTrustedRequest().Referer()
+}
 
-	ips = append(ips, f.GetTrustedRemoteAddr().String())
+// forwardHeaders is the list of forwarding related headers in addition to the X-Forwarded-* ones
+var forwardHeaders = []string{
+	"CF-Connecting-IP",
+	"Forwarded",
+	"True-Client-IP",
+	"X-Real-IP",
+}
 
-	req.Header.Set("X-Forwarded-For", strings.Join(ips, ", "))
-	req.Header.Set("X-Forwarded-Host", f.GetTrustedHost())
-	req.Header.Set("X-Forwarded-Proto", f.GetTrustedProto())
+// snapshotForwardHeaders returns a copy of the forwarding related headers and the extra headers
+func snapshotForwardHeaders(h http.Header, extra []string) http.Header {
+	res := http.Header{}
+	for name, values := range h {
+		if strings.HasPrefix(name, "X-Forwarded-") {
+			res[name] = append([]string{}, values...)
+		}
+	}
+	for _, names := range [][]string{forwardHeaders, extra} {
+		for _, name := range names {
+			if values := h.Values(name); len(values) > 0 {
+				res[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+			}
+		}
+	}
+	return res
+}
 
-	return req
+func equalIPs(a []net.IP, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,49 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// ResolveChain runs the trust walk of the extractor over the ip chain received from the peer, so the protocols
+// other than http, e.g. the SMTP XCLIENT command, share the trust policy of the http edge. Only the ip related
+// fields of the result are filled.
+func ResolveChain(e IPExtractor, peer net.IP, chain []net.IP) (Result, error) {
+	proxy, remote, rest, err := e.Resolve(peer, chain)
+	if err != nil {
+		return Result{}, err
+	}
+	if remote, err = attributeRemote(proxy, remote, peer); err != nil {
+		return Result{}, err
+	}
+	res := Result{
+		ProxyIP:      cloneIP(proxy),
+		RemoteAddr:   cloneIP(remote),
+		ForwardedFor: cloneIPs(rest),
+	}
+	if proxy != nil {
+		res.Proxies = cloneIPs(proxyPath(append(append([]net.IP{}, chain...), peer), rest))
+	}
+	return res, nil
+}
+
+// attributeRemote returns the remote address of a resolved chain, a trusted proxy must attest a remote address
+// while an untrusted request without one is attributed to the peer
+func attributeRemote(proxy net.IP, remote net.IP, peer net.IP) (net.IP, error) {
+	if remote != nil {
+		return remote, nil
+	}
+	if proxy != nil {
+		return nil, fmt.Errorf("extractor returned proxy %s without a remote address", proxy)
+	}
+	return peer, nil
+}
+
+// proxyPath returns the hops of the full chain after the remote address, which is right after the rest
+// of the chain the extractor returned
+func proxyPath(full []net.IP, rest []net.IP) []net.IP {
+	if len(rest)+1 >= len(full) {
+		return nil
+	}
+	return full[len(rest)+1:]
+}
@@ -0,0 +1,26 @@
+package trustedproxy
+
+import (
+	"context"
+	"net"
+)
+
+// Resolver is the name resolution used by the DNS based features, *net.Resolver implements it, so the
+// lookups can be routed through an internal DNS, cached or stubbed.
+type Resolver interface {
+	// LookupIP looks up the ips of the host for the network, "ip", "ip4" or "ip6".
+	LookupIP(ctx context.Context, network string, host string) ([]net.IP, error)
+
+	// LookupAddr looks up the names of the address.
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+var _ Resolver = net.DefaultResolver
+
+// resolverOrDefault returns the resolver, net.DefaultResolver if it is nil
+func resolverOrDefault(r Resolver) Resolver {
+	if r == nil {
+		return net.DefaultResolver
+	}
+	return r
+}
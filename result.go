@@ -0,0 +1,144 @@
+package trustedproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Result is a snapshot of the trusted values resolved for a request, modifying it does not affect
+// the ForwardedRequest it comes from.
+type Result struct {
+	// ProxyIP is the ip of the trusted proxy, nil if the request is not coming from a trusted proxy.
+	ProxyIP net.IP
+
+	// RemoteAddr is the trusted remote address.
+	RemoteAddr net.IP
+
+	// ForwardedFor is the rest of the ip chain before the trusted remote address.
+	ForwardedFor Chain
+
+	// Proxies is the hops between the trusted remote address and this server, the last one is the
+	// immediate peer. It is empty if the request is not coming from a trusted proxy.
+	Proxies Chain
+
+	// PeerPort is the source port of the immediate peer, 0 if unknown, e.g. over a unix socket.
+	PeerPort int
+
+	// Host is the trusted host.
+	Host string
+
+	// Proto is the trusted protocol, either "http" or "https".
+	Proto string
+
+	// Port is the trusted port.
+	Port int
+
+	// Timings is the time spent in the stages of the handler, zero unless HTTPHandler.Profile is set.
+	Timings StageTimings
+
+	// Degraded is true if the extractor failed and the immediate peer is used as the remote address
+	// because of HTTPHandler.SoftFail.
+	Degraded bool
+}
+
+// WithOverride returns a shallow copy of the request whose context carries a ForwardedRequest with the
+// values of the result, empty host, proto and port are resolved from the request as usual. It is meant for
+// tests which need to fake the values seen by downstream handlers without running the middleware.
+func WithOverride(r *http.Request, res Result) *http.Request {
+	fr := &forwardedRequest{
+		proxyIP:             cloneIP(res.ProxyIP),
+		trustedHost:         res.Host,
+		trustedProto:        res.Proto,
+		trustedPort:         res.Port,
+		degraded:            res.Degraded,
+		trustedRemoteAddr:   cloneIP(res.RemoteAddr),
+		trustedForwardedFor: cloneIPs(res.ForwardedFor),
+		trustedProxies:      cloneIPs(res.Proxies),
+		peerPort:            res.PeerPort,
+	}
+	r = r.WithContext(context.WithValue(r.Context(), CtxKeyForwardedRequest, fr))
+	fr.Request = r
+	fr.originalHeaders = snapshotForwardHeaders(r.Header, nil)
+	fr.init()
+	return r
+}
+
+// NewContextWithResult returns a copy of ctx carrying a ForwardedRequest with the values of the result,
+// so tests of downstream handlers can inject a fake trusted result without running the middleware.
+// The request behind the ForwardedRequest is a placeholder GET request to "/" on the trusted host,
+// use WithOverride instead when the handler also needs the real request.
+func NewContextWithResult(ctx context.Context, res Result) context.Context {
+	r := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: "/"},
+		Header: http.Header{},
+		Host:   res.Host,
+	}
+	return WithOverride(r.WithContext(ctx), res).Context()
+}
+
+// FromContext returns the ForwardedRequest stored in the context by the middleware,
+// NewContextWithResult or WithOverride.
+func FromContext(ctx context.Context) (ForwardedRequest, bool) {
+	fr, ok := ctx.Value(CtxKeyForwardedRequest).(*forwardedRequest)
+	if !ok {
+		return nil, false
+	}
+	return fr, true
+}
+
+// ChainFingerprint returns a stable hash over the proxy path of the request, so the requests traversing the
+// same proxies can be grouped during investigations without storing the whole chains. The hops are hashed in
+// their normalized node form, "unknown" for the undisclosed ones, each prefixed with its length. The remote
+// address is not part of the fingerprint, and "" is returned if the request is not coming from a trusted proxy.
+func (r Result) ChainFingerprint() string {
+	if len(r.Proxies) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	var size [4]byte
+	for _, ip := range r.Proxies {
+		node := "unknown"
+		if ip != nil {
+			node = forwardedNode(ip)
+		}
+		binary.BigEndian.PutUint32(size[:], uint32(len(node)))
+		h.Write(size[:])
+		h.Write([]byte(node))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// The predicates below classify every combination of the resolved values:
+//
+//	ProxyIP  RemoteAddr  ForwardedFor  IsDirect  IsTrusted  HasChain
+//	nil      nil         any           false     false      false     zero Result, nothing resolved
+//	nil      set         empty         true      false      false     direct connection
+//	nil      set         non-empty     true      false      false     direct connection claiming a chain
+//	set      nil         any           false     false      false     invalid, rejected by HTTPHandler
+//	set      set         empty         false     true       false     the client connected to the proxy
+//	set      set         non-empty     false     true       true      the client came through more hops
+//
+// The chain claimed by a direct connection is kept in ForwardedFor so it can be passed on, but nothing in it
+// is attested, so HasChain does not count it. A degraded result is always direct.
+
+// IsDirect returns true if the remote address is the immediate peer, i.e. the request is not coming from a
+// trusted proxy.
+func (r Result) IsDirect() bool {
+	return r.ProxyIP == nil && r.RemoteAddr != nil
+}
+
+// IsTrusted returns true if the remote address is attested by a trusted proxy.
+func (r Result) IsTrusted() bool {
+	return r.ProxyIP != nil && r.RemoteAddr != nil
+}
+
+// HasChain returns true if a trusted proxy forwarded more hops before the remote address.
+func (r Result) HasChain() bool {
+	return r.IsTrusted() && len(r.ForwardedFor) > 0
+}
@@ -0,0 +1,113 @@
+package trustedproxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResultPredicates(t *testing.T) {
+	peer := net.ParseIP("10.0.0.2")
+	client := net.ParseIP("203.0.113.7")
+	chain := Chain{net.ParseIP("192.0.2.66")}
+	tests := []struct {
+		name                      string
+		res                       Result
+		direct, trusted, hasChain bool
+	}{
+		{"zero result", Result{}, false, false, false},
+		{"nil remote with chain", Result{ForwardedFor: chain}, false, false, false},
+		{"direct connection", Result{RemoteAddr: client}, true, false, false},
+		{"direct connection claiming a chain", Result{RemoteAddr: client, ForwardedFor: chain}, true, false, false},
+		{"nil proxy with proxies", Result{RemoteAddr: client, Proxies: Chain{peer}}, true, false, false},
+		{"degraded", Result{RemoteAddr: peer, Degraded: true}, true, false, false},
+		{"proxy without remote", Result{ProxyIP: peer}, false, false, false},
+		{"proxy without remote with chain", Result{ProxyIP: peer, ForwardedFor: chain}, false, false, false},
+		{"trusted peer with empty chain", Result{ProxyIP: peer, RemoteAddr: client}, false, true, false},
+		{"trusted peer with empty non-nil chain", Result{ProxyIP: peer, RemoteAddr: client, ForwardedFor: Chain{}}, false, true, false},
+		{"trusted peer with chain", Result{ProxyIP: peer, RemoteAddr: client, ForwardedFor: chain}, false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.res.IsDirect(); got != tt.direct {
+				t.Errorf("IsDirect() = %v, want %v", got, tt.direct)
+			}
+			if got := tt.res.IsTrusted(); got != tt.trusted {
+				t.Errorf("IsTrusted() = %v, want %v", got, tt.trusted)
+			}
+			if got := tt.res.HasChain(); got != tt.hasChain {
+				t.Errorf("HasChain() = %v, want %v", got, tt.hasChain)
+			}
+		})
+	}
+}
+
+func TestChainFingerprint(t *testing.T) {
+	a := net.ParseIP("10.0.0.1")
+	b := net.ParseIP("10.0.0.2")
+	fingerprint := func(proxies ...net.IP) string {
+		return Result{Proxies: proxies}.ChainFingerprint()
+	}
+	if fp := fingerprint(); fp != "" {
+		t.Errorf("fingerprint of a direct request is %q", fp)
+	}
+	tests := []struct {
+		name  string
+		x, y  Chain
+		equal bool
+	}{
+		{"same path", Chain{a, b}, Chain{a, b}, true},
+		{"reordered", Chain{a, b}, Chain{b, a}, false},
+		{"unknown hop is not skipped", Chain{a, nil, b}, Chain{a, b}, false},
+		{"unknown hop is not 0.0.0.0", Chain{a, nil, b}, Chain{a, UnknownHopIP, b}, false},
+		{"ipv4 mapped ipv6 is ipv4", Chain{a.To4()}, Chain{a.To16()}, true},
+		{"ipv6", Chain{net.ParseIP("2001:db8::1")}, Chain{net.ParseIP("2001:db8::2")}, false},
+		{"hop boundaries", Chain{net.ParseIP("1.1.1.1"), net.ParseIP("1.1.1.11")}, Chain{net.ParseIP("1.1.1.11"), net.ParseIP("1.1.1.1")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y := fingerprint(tt.x...), fingerprint(tt.y...)
+			if x == "" || y == "" {
+				t.Fatalf("empty fingerprint %q %q", x, y)
+			}
+			if (x == y) != tt.equal {
+				t.Errorf("fingerprints %q and %q, want equal %v", x, y, tt.equal)
+			}
+		})
+	}
+}
+
+func TestChainFingerprintExposed(t *testing.T) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := NewEventBroker()
+	ch, unsubscribe := events.Subscribe(1)
+	defer unsubscribe()
+	h := &HTTPHandler{Extractor: cdn, Events: events}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "10.0.0.2:4711"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	var fp string
+	var dump []byte
+	h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _ := FromContext(r.Context())
+		fp = f.GetResult().ChainFingerprint()
+		dump, err = DumpTrustedRequest(f, DumpOptions{})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp == "" {
+		t.Fatal("no fingerprint for a trusted request")
+	}
+	if e := <-ch; e.Fingerprint != fp {
+		t.Errorf("event fingerprint %q, want %q", e.Fingerprint, fp)
+	}
+	if !strings.Contains(string(dump), "X-Trusted-Chain-Fingerprint: "+fp+"\r\n") {
+		t.Errorf("fingerprint missing in the dump:\n%s", dump)
+	}
+}
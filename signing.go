@@ -0,0 +1,219 @@
+package trustedproxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSignatureHeader is the header used by HMACSigner when Header is empty.
+const DefaultSignatureHeader = "X-Forwarded-Signature"
+
+// DefaultSignedHeaders is the list of headers covered by HMACSigner when SignedHeaders is empty.
+var DefaultSignedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Prefix",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+}
+
+// ForwardHook is the function invoked on the requests produced by BuildRequestForForward,
+// e.g. to sign the forwarded headers.
+type ForwardHook func(req *http.Request)
+
+// HMACSigner is a reference ForwardHook implementation which signs the forwarded headers with HMAC-SHA256,
+// so the upstream can detect the headers being tampered between the gateway and the upstream.
+// The signature header has the form: keyid="<KeyID>",ts=<unix seconds>,sig="<base64 signature>", with a
+// nonce="<random>" pair before the signature when Nonce is set.
+type HMACSigner struct {
+	// KeyID identifies the key to the verifier.
+	KeyID string
+
+	// Key is the shared secret.
+	Key []byte
+
+	// KeySet takes precedence over KeyID and Key when set, the request is left unsigned if it has no active key.
+	KeySet *KeySet
+
+	// Header is the name of the signature header, DefaultSignatureHeader is used if empty.
+	Header string
+
+	// SignedHeaders is the list of headers covered by the signature, DefaultSignedHeaders is used if empty.
+	SignedHeaders []string
+
+	// Nonce adds a random nonce covered by the signature, required by the verifiers with a ReplayCache.
+	Nonce bool
+}
+
+// Sign sets the signature header of the request, it can be used as a ForwardHook.
+func (s *HMACSigner) Sign(req *http.Request) {
+	now := time.Now()
+	keyID, secret := s.KeyID, s.Key
+	if s.KeySet != nil {
+		key, ok := s.KeySet.Signing(now)
+		if !ok {
+			return
+		}
+		keyID, secret = key.ID, key.Secret
+	}
+	ts := now.Unix()
+	value := `keyid="` + keyID + `",ts=` + strconv.FormatInt(ts, 10)
+	var nonce string
+	if s.Nonce {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return
+		}
+		nonce = base64.RawURLEncoding.EncodeToString(b[:])
+		value += `,nonce="` + nonce + `"`
+	}
+	sig := signForward(secret, req, ts, nonce, s.SignedHeaders)
+	req.Header.Set(headerOrDefault(s.Header, DefaultSignatureHeader),
+		value+`,sig="`+base64.StdEncoding.EncodeToString(sig)+`"`)
+}
+
+// signForward returns the HMAC-SHA256 over the canonical form of the request:
+// ts, method, host, request uri and each signed header on its own line, the nonce follows the ts if any.
+func signForward(key []byte, req *http.Request, ts int64, nonce string, headers []string) []byte {
+	if len(headers) == 0 {
+		headers = DefaultSignedHeaders
+	}
+	mac := hmac.New(sha256.New, key)
+	var b strings.Builder
+	b.WriteString(strconv.FormatInt(ts, 10))
+	b.WriteByte('\n')
+	if nonce != "" {
+		b.WriteString("nonce:")
+		b.WriteString(nonce)
+		b.WriteByte('\n')
+	}
+	b.WriteString(req.Method)
+	b.WriteByte('\n')
+	b.WriteString(req.Host)
+	b.WriteByte('\n')
+	b.WriteString(req.URL.RequestURI())
+	for _, name := range headers {
+		b.WriteByte('\n')
+		b.WriteString(strings.ToLower(name))
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	mac.Write([]byte(b.String()))
+	return mac.Sum(nil)
+}
+
+func headerOrDefault(name string, def string) string {
+	if name == "" {
+		return def
+	}
+	return name
+}
+
+// DefaultSignatureMaxSkew is the max clock skew accepted by HMACVerifier when MaxSkew is zero.
+const DefaultSignatureMaxSkew = 5 * time.Minute
+
+// SignatureMode is how HTTPHandler combines the signature verification with the ip trust.
+type SignatureMode uint
+
+const (
+	// SignatureRequired requires a correct signature in addition to the Extractor trusting the peer,
+	// requests without a correct signature are treated as not coming from a trusted proxy. It is the
+	// default mode.
+	SignatureRequired SignatureMode = iota
+
+	// SignatureSufficient trusts the peer of a correctly signed request regardless of its ip, bypassing the
+	// Extractor for the peer, other requests are resolved by the Extractor as usual. It must be opted in
+	// explicitly, any peer holding a key is then trusted.
+	SignatureSufficient
+)
+
+// HMACVerifier verifies the signatures produced by HMACSigner.
+type HMACVerifier struct {
+	// Keys is the shared secrets by key id, more than one key can be active during rotation.
+	Keys map[string][]byte
+
+	// KeySet takes precedence over Keys when set, only the keys active at the signature timestamp are accepted.
+	KeySet *KeySet
+
+	// Header is the name of the signature header, DefaultSignatureHeader is used if empty.
+	Header string
+
+	// SignedHeaders is the list of headers covered by the signature, DefaultSignedHeaders is used if empty.
+	SignedHeaders []string
+
+	// MaxSkew is the max difference between the signature timestamp and now,
+	// DefaultSignatureMaxSkew is used if zero.
+	MaxSkew time.Duration
+
+	// Replay rejects the signatures seen before when set, the signatures must then carry a nonce,
+	// see HMACSigner.Nonce.
+	Replay *ReplayCache
+}
+
+// Verify returns nil if the request carries a correct signature.
+func (v *HMACVerifier) Verify(r *http.Request) error {
+	value := r.Header.Get(headerOrDefault(v.Header, DefaultSignatureHeader))
+	if value == "" {
+		return errors.New("missing forward signature")
+	}
+	var keyID, ts, nonce, sig string
+	for _, pair := range splitQuoted(value, ',') {
+		key, val, err := splitPair(pair)
+		if err != nil {
+			return fmt.Errorf("invalid forward signature: %w", err)
+		}
+		switch key {
+		case "keyid":
+			keyID = val
+		case "ts":
+			ts = val
+		case "nonce":
+			nonce = val
+		case "sig":
+			sig = val
+		}
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid forward signature timestamp: %w", err)
+	}
+	key, ok := v.Keys[keyID]
+	if v.KeySet != nil {
+		key, ok = v.KeySet.Lookup(keyID, time.Unix(sec, 0))
+	}
+	if !ok {
+		return fmt.Errorf("unknown forward signature key %q", keyID)
+	}
+	maxSkew := v.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = DefaultSignatureMaxSkew
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+		return errors.New("forward signature expired")
+	}
+	expected, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("invalid forward signature: %w", err)
+	}
+	if !hmac.Equal(expected, signForward(key, r, sec, nonce, v.SignedHeaders)) {
+		return errors.New("forward signature mismatch")
+	}
+	if v.Replay == nil {
+		return nil
+	}
+	if nonce == "" {
+		return errors.New("missing forward signature nonce")
+	}
+	// the nonce is only checked once the signature is correct, so forged requests cannot fill the cache
+	return v.Replay.Check(keyID+"/"+nonce, time.Unix(sec, 0).Add(maxSkew))
+}
@@ -0,0 +1,58 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignatureMode(t *testing.T) {
+	whitelist, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("secret")
+	signer := &HMACSigner{KeyID: "k1", Key: key}
+	tests := []struct {
+		name   string
+		mode   SignatureMode
+		peer   string
+		signed bool
+		remote string
+	}{
+		{"default mode ignores signature of untrusted peer", SignatureRequired, "203.0.113.50:4711", true, "203.0.113.50"},
+		{"default mode rejects unsigned trusted peer", SignatureRequired, "10.0.0.2:4711", false, "10.0.0.2"},
+		{"default mode trusts signed trusted peer", SignatureRequired, "10.0.0.2:4711", true, "203.0.113.7"},
+		{"sufficient trusts signed untrusted peer", SignatureSufficient, "203.0.113.50:4711", true, "203.0.113.7"},
+		{"sufficient falls back to extractor", SignatureSufficient, "10.0.0.2:4711", false, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTTPHandler{
+				Extractor:     whitelist,
+				Verifier:      &HMACVerifier{Keys: map[string][]byte{"k1": key}},
+				SignatureMode: tt.mode,
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.peer
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			if tt.signed {
+				signer.Sign(r)
+			}
+			var got Result
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = ResultFromContext(r.Context())
+			}))
+			if got.RemoteAddr.String() != tt.remote {
+				t.Errorf("remote address is %v, want %s", got.RemoteAddr, tt.remote)
+			}
+		})
+	}
+}
+
+func TestSignatureModeZeroValue(t *testing.T) {
+	var h HTTPHandler
+	if h.SignatureMode != SignatureRequired {
+		t.Errorf("zero SignatureMode is %v, want required", h.SignatureMode)
+	}
+}
@@ -0,0 +1,49 @@
+package trustedproxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SNIExtractor selects the extractor by the TLS server name the proxy used to reach this server, for
+// gateways terminating several certificates where each domain sits behind a different edge provider.
+type SNIExtractor struct {
+	// ServerNames is the extractors by server name, a "*.example.com" key matches one level of subdomain
+	// when there is no exact match.
+	ServerNames map[string]IPExtractor
+
+	// Default is used for the plain text connections and the unmatched server names, the request is treated
+	// as not coming from a trusted proxy if it is nil.
+	Default IPExtractor
+}
+
+func (s *SNIExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return s.ResolveRequest(nil, &net.TCPAddr{IP: remote}, forwarded)
+}
+
+func (s *SNIExtractor) ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	e := s.Default
+	if r != nil && r.TLS != nil {
+		if m := s.match(strings.ToLower(r.TLS.ServerName)); m != nil {
+			e = m
+		}
+	}
+	if e == nil {
+		return nil, remote.IP, forwarded, nil
+	}
+	return resolveRequest(e, r, remote, forwarded)
+}
+
+func (s *SNIExtractor) match(name string) IPExtractor {
+	if name == "" {
+		return nil
+	}
+	if e, ok := s.ServerNames[name]; ok {
+		return e
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return s.ServerNames["*"+name[i:]]
+	}
+	return nil
+}
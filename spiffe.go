@@ -0,0 +1,70 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+)
+
+// SPIFFEPeerExtractor trusts the immediate peer by the SPIFFE id in the URI SAN of its verified client certificate
+// instead of its ip, so the mesh identity defines the proxy boundary. The server must request and verify the
+// client certificates, the requests without a verified certificate are treated as not coming from a trusted proxy.
+// see https://github.com/spiffe/spiffe/blob/main/standards/X509-SVID.md
+type SPIFFEPeerExtractor struct {
+	// IDs is the path.Match patterns of the trusted SPIFFE ids, e.g. "spiffe://cluster/ns/ingress/*".
+	IDs []string
+
+	// Upstream resolves the hops in front of a trusted peer, e.g. a CIDRWhitelist of the load balancers before
+	// the ingress. The last ip of the chain is the remote ip if it is nil or does not trust the hops.
+	Upstream IPExtractor
+}
+
+func (s *SPIFFEPeerExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	// the identity is only known with the request
+	return nil, remote, forwarded, nil
+}
+
+func (s *SPIFFEPeerExtractor) ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	trusted, err := s.trustedPeer(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !trusted || len(forwarded) == 0 {
+		return nil, remote.IP, forwarded, nil
+	}
+	client, rest := pop(forwarded)
+	if s.Upstream != nil {
+		proxy, upstreamRemote, upstreamRest, err := resolveRequest(s.Upstream, r, &net.TCPAddr{IP: client}, rest)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if proxy != nil {
+			return proxy, upstreamRemote, upstreamRest, nil
+		}
+	}
+	return remote.IP, client, rest, nil
+}
+
+// trustedPeer returns true if the verified client certificate of the request has a trusted SPIFFE id
+func (s *SPIFFEPeerExtractor) trustedPeer(r *http.Request) (bool, error) {
+	if r == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return false, nil
+	}
+	for _, u := range r.TLS.PeerCertificates[0].URIs {
+		if u.Scheme != "spiffe" {
+			continue
+		}
+		id := u.String()
+		for _, pattern := range s.IDs {
+			ok, err := path.Match(pattern, id)
+			if err != nil {
+				return false, fmt.Errorf("invalid spiffe id pattern %q: %w", pattern, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
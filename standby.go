@@ -0,0 +1,159 @@
+package trustedproxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const (
+	// MetricShadowMatch is incremented when the candidate of a StandbyExtractor agrees with the active one.
+	MetricShadowMatch = "shadow_match"
+
+	// MetricShadowMismatch is incremented when the candidate of a StandbyExtractor disagrees with the active one.
+	MetricShadowMismatch = "shadow_mismatch"
+)
+
+// ShadowResult is the outcome of an extractor evaluated by StandbyExtractor.
+type ShadowResult struct {
+	ProxyIP      net.IP
+	RemoteAddr   net.IP
+	ForwardedFor []net.IP
+	Err          error
+}
+
+// StandbyExtractor runs a candidate trust configuration in the shadow of the active one, for a blue/green
+// rollout of trust policy changes: every request is resolved by the active extractor while the candidate
+// is evaluated on the same chain and compared, then Promote makes the candidate active in one step and
+// Demote rolls it back.
+type StandbyExtractor struct {
+	// Metrics receives MetricShadowMatch and MetricShadowMismatch, no metrics are recorded if it is nil.
+	Metrics Metrics
+
+	// OnMismatch is invoked with both outcomes when they disagree, r is nil when resolved without a request.
+	// A panic of the candidate is a disagreement, with the recovered *HookError as the error of the candidate.
+	// A panic of OnMismatch itself is recovered and logged with the standard logger.
+	OnMismatch func(r *http.Request, active ShadowResult, candidate ShadowResult)
+
+	mu        sync.RWMutex
+	active    IPExtractor
+	candidate IPExtractor
+	promoted  bool
+}
+
+// NewStandbyExtractor returns a StandbyExtractor with the active extractor and no candidate.
+func NewStandbyExtractor(active IPExtractor) *StandbyExtractor {
+	return &StandbyExtractor{active: active}
+}
+
+// SetCandidate replaces the candidate, nil stops the shadow evaluation.
+func (s *StandbyExtractor) SetCandidate(candidate IPExtractor) {
+	s.mu.Lock()
+	s.candidate = candidate
+	s.promoted = false
+	s.mu.Unlock()
+}
+
+// Promote makes the candidate active and keeps the previous active one as the candidate, so it stays
+// compared until Demote or SetCandidate. False is returned if there is no candidate or it is already promoted.
+func (s *StandbyExtractor) Promote() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.candidate == nil || s.promoted {
+		return false
+	}
+	s.active, s.candidate = s.candidate, s.active
+	s.promoted = true
+	return true
+}
+
+// Demote reverts the last Promote, false is returned if nothing is promoted.
+func (s *StandbyExtractor) Demote() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.promoted {
+		return false
+	}
+	s.active, s.candidate = s.candidate, s.active
+	s.promoted = false
+	return true
+}
+
+// Active returns the active extractor.
+func (s *StandbyExtractor) Active() IPExtractor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+// Candidate returns the candidate extractor, nil if there is none.
+func (s *StandbyExtractor) Candidate() IPExtractor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.candidate
+}
+
+func (s *StandbyExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	return s.ResolveRequest(nil, &net.TCPAddr{IP: remote}, forwarded)
+}
+
+func (s *StandbyExtractor) ResolveRequest(r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	s.mu.RLock()
+	active, candidate := s.active, s.candidate
+	s.mu.RUnlock()
+	if candidate == nil {
+		return resolveRequest(active, r, remote, forwarded)
+	}
+	// the candidate gets its own copy so the active resolution cannot disturb it
+	shadowChain := cloneIPs(forwarded)
+	var a ShadowResult
+	a.ProxyIP, a.RemoteAddr, a.ForwardedFor, a.Err = resolveRequest(active, r, remote, forwarded)
+	s.shadow(r, candidate, &net.TCPAddr{IP: remote.IP, Port: remote.Port}, shadowChain, a)
+	return a.ProxyIP, a.RemoteAddr, a.ForwardedFor, a.Err
+}
+
+// shadow evaluates the candidate and compares it with the outcome of the active extractor, the panics of the
+// candidate and of OnMismatch are recovered so the shadow evaluation never affects the active path
+func (s *StandbyExtractor) shadow(r *http.Request, candidate IPExtractor, remote *net.TCPAddr, forwarded []net.IP, a ShadowResult) {
+	c, panicked := resolveShadow(candidate, r, remote, forwarded)
+	if !panicked && a.agrees(c) {
+		if s.Metrics != nil {
+			s.Metrics.IncCounter(MetricShadowMatch)
+		}
+		return
+	}
+	if s.Metrics != nil {
+		s.Metrics.IncCounter(MetricShadowMismatch)
+	}
+	if s.OnMismatch == nil {
+		return
+	}
+	// the callback gets copies, the outcome of the active extractor is returned to the handler
+	a = ShadowResult{ProxyIP: cloneIP(a.ProxyIP), RemoteAddr: cloneIP(a.RemoteAddr), ForwardedFor: cloneIPs(a.ForwardedFor), Err: a.Err}
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("trustedproxy: %v", &HookError{Hook: "OnMismatch", Err: panicError(p), Panicked: true})
+		}
+	}()
+	s.OnMismatch(r, a, c)
+}
+
+// resolveShadow resolves the chain with the candidate, a panic is returned as the error of the result
+func resolveShadow(candidate IPExtractor, r *http.Request, remote *net.TCPAddr, forwarded []net.IP) (c ShadowResult, panicked bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			c = ShadowResult{Err: &HookError{Hook: "candidate", Err: panicError(p), Panicked: true}}
+			panicked = true
+		}
+	}()
+	c.ProxyIP, c.RemoteAddr, c.ForwardedFor, c.Err = resolveRequest(candidate, r, remote, forwarded)
+	return c, false
+}
+
+func (a ShadowResult) agrees(b ShadowResult) bool {
+	if (a.Err == nil) != (b.Err == nil) {
+		return false
+	}
+	return a.ProxyIP.Equal(b.ProxyIP) && a.RemoteAddr.Equal(b.RemoteAddr) && equalIPs(a.ForwardedFor, b.ForwardedFor)
+}
@@ -0,0 +1,91 @@
+package trustedproxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+)
+
+type panicExtractor struct{}
+
+func (panicExtractor) Resolve(remote net.IP, forwarded []net.IP) (net.IP, net.IP, []net.IP, error) {
+	panic("candidate misconfigured")
+}
+
+func TestStandbyExtractorPromote(t *testing.T) {
+	active := mustWhitelist(t, "10.0.0.0/8")
+	candidate := mustWhitelist(t, "10.0.0.0/16")
+	s := NewStandbyExtractor(active)
+	if s.Promote() || s.Demote() {
+		t.Fatal("promoted or demoted without a candidate")
+	}
+	s.SetCandidate(candidate)
+	if !s.Promote() || s.Active() != candidate || s.Candidate() != active {
+		t.Fatal("the candidate is not promoted")
+	}
+	if s.Promote() {
+		t.Error("promoted twice")
+	}
+	if !s.Demote() || s.Active() != active || s.Candidate() != candidate {
+		t.Fatal("the promotion is not reverted")
+	}
+	if s.Demote() {
+		t.Error("demoted twice")
+	}
+	s.SetCandidate(nil)
+	if s.Candidate() != nil || s.Promote() {
+		t.Error("the candidate is not removed")
+	}
+}
+
+func TestStandbyExtractorShadow(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidate  IPExtractor
+		peer       string
+		match      int
+		mismatch   int
+		candidateE bool
+	}{
+		{"agreeing candidate", mustWhitelist(t, "10.0.0.0/16"), "10.0.0.2", 1, 0, false},
+		{"disagreeing candidate", mustWhitelist(t, "10.0.0.0/16"), "10.1.0.2", 0, 1, false},
+		{"panicking candidate", panicExtractor{}, "10.0.0.2", 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &counterMetrics{}
+			var got []ShadowResult
+			s := NewStandbyExtractor(mustWhitelist(t, "10.0.0.0/8"))
+			s.Metrics = m
+			s.OnMismatch = func(r *http.Request, active ShadowResult, candidate ShadowResult) {
+				got = append(got, candidate)
+				active.ForwardedFor[0][0] = 1
+			}
+			s.SetCandidate(tt.candidate)
+			proxy, remote, rest, err := s.Resolve(net.ParseIP(tt.peer), parseIPs([]string{"192.0.2.66", "203.0.113.7"}))
+			if err != nil || !proxy.Equal(net.ParseIP(tt.peer)) || remote.String() != "203.0.113.7" || ipStrings(rest)[0] != "192.0.2.66" {
+				t.Fatalf("active resolved %v %v %v %v", proxy, remote, rest, err)
+			}
+			if m.count(MetricShadowMatch) != tt.match || m.count(MetricShadowMismatch) != tt.mismatch || len(got) != tt.mismatch {
+				t.Errorf("%d matches, %d mismatches and %d callbacks", m.count(MetricShadowMatch), m.count(MetricShadowMismatch), len(got))
+			}
+			var hookErr *HookError
+			if tt.candidateE && (len(got) != 1 || !errors.As(got[0].Err, &hookErr) || !hookErr.Panicked) {
+				t.Errorf("candidate outcome %+v, want the recovered panic", got)
+			}
+		})
+	}
+}
+
+func TestStandbyExtractorMismatchPanic(t *testing.T) {
+	s := NewStandbyExtractor(mustWhitelist(t, "10.0.0.0/8"))
+	s.SetCandidate(TrustNone{})
+	s.OnMismatch = func(r *http.Request, active ShadowResult, candidate ShadowResult) {
+		panic("callback bug")
+	}
+	_, remote, _, err := s.Resolve(net.ParseIP("10.0.0.2"), parseIPs([]string{"203.0.113.7"}))
+	if err != nil || remote.String() != "203.0.113.7" {
+		t.Errorf("active resolved %v %v", remote, err)
+	}
+}
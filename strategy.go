@@ -0,0 +1,47 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// Strategy is how the remote address is picked from the chain of a request trusted by the extractor.
+type Strategy uint8
+
+const (
+	// StrategyRightmostUntrusted uses the result of the extractor, which walks the chain from the right until
+	// the first untrusted ip.
+	StrategyRightmostUntrusted Strategy = iota
+
+	// StrategyLeftmostPublic uses the leftmost ip of the chain which is not private, loopback, link-local or
+	// unspecified, as some legacy systems expect. It only applies once the extractor trusts the request, and
+	// the entries it picks from may be supplied by the client, so it should only be used when the hops in
+	// front of the proxies are known to be honest.
+	StrategyLeftmostPublic
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategyRightmostUntrusted:
+		return "rightmost-untrusted"
+	case StrategyLeftmostPublic:
+		return "leftmost-public"
+	}
+	return fmt.Sprintf("Strategy(%d)", uint(s))
+}
+
+// leftmostPublic returns the proxy ip, remote ip and the rest of the chain with the leftmost public ip of the
+// forwarded ips as the remote ip, false if there is none
+func leftmostPublic(forwarded []net.IP, peer net.IP) (net.IP, net.IP, []net.IP, bool) {
+	full := append(append([]net.IP{}, forwarded...), peer)
+	for i, ip := range forwarded {
+		if isPublicIP(ip) {
+			return full[i+1], ip, full[:i], true
+		}
+	}
+	return nil, nil, nil, false
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsUnspecified()
+}
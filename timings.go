@@ -0,0 +1,57 @@
+package trustedproxy
+
+import "time"
+
+const (
+	// MetricStageParse, MetricStageResolve, MetricStageClone and MetricStageEnrich are the durations observed
+	// for the stages of HTTPHandler when Profile is set.
+	MetricStageParse   = "stage_parse"
+	MetricStageResolve = "stage_resolve"
+	MetricStageClone   = "stage_clone"
+	MetricStageEnrich  = "stage_enrich"
+)
+
+// StageTimings is the time spent in the stages of HTTPHandler for a request, recorded when HTTPHandler.Profile
+// is set.
+type StageTimings struct {
+	// Parse is the time spent extracting the ip chain from the headers or the ChainSource.
+	Parse time.Duration
+
+	// Resolve is the time spent in the trust walk and resolving the trusted values.
+	Resolve time.Duration
+
+	// Clone is the time spent cloning the request and snapshotting its forwarding headers.
+	Clone time.Duration
+
+	// Enrich is the time spent in the Enricher, zero until GetEnrichment is called.
+	Enrich time.Duration
+}
+
+// DurationMetrics is an optional extension of Metrics receiving the stage durations recorded when
+// HTTPHandler.Profile is set, e.g. to feed a histogram.
+type DurationMetrics interface {
+	Metrics
+
+	// ObserveDuration records a duration of the stage with the name.
+	ObserveDuration(name string, d time.Duration)
+}
+
+// startStage returns the start time of a stage, the zero time if the handler is not profiling
+func (h *HTTPHandler) startStage() time.Time {
+	if h == nil || !h.Profile {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// endStage returns the duration of the stage started at start and observes it in the metrics
+func (h *HTTPHandler) endStage(name string, start time.Time) time.Duration {
+	if start.IsZero() {
+		return 0
+	}
+	d := time.Since(start)
+	if m, ok := h.Metrics.(DurationMetrics); ok {
+		m.ObserveDuration(name, d)
+	}
+	return d
+}
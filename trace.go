@@ -0,0 +1,140 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HopVerdict is how a hop of the forwarded chain was treated by the handler.
+type HopVerdict uint8
+
+const (
+	// HopUntrusted is a hop before the trusted remote address, or any hop of a request not coming from a
+	// trusted proxy, its claims are not attested.
+	HopUntrusted HopVerdict = iota
+
+	// HopClient is the trusted remote address.
+	HopClient
+
+	// HopTrusted is a trusted proxy between the remote address and this server.
+	HopTrusted
+
+	// HopSkipped is an undisclosed hop dropped by UnknownHopSkip.
+	HopSkipped
+
+	// HopDiscarded is a hop at or before the undisclosed hop UnknownHopTerminate stopped the walk at.
+	HopDiscarded
+
+	// HopBoundary is an undisclosed hop kept as a boundary by UnknownHopBoundary.
+	HopBoundary
+
+	// HopMalformed is an entry which is not an ip, dropped from the chain.
+	HopMalformed
+)
+
+func (v HopVerdict) String() string {
+	switch v {
+	case HopUntrusted:
+		return "untrusted"
+	case HopClient:
+		return "client"
+	case HopTrusted:
+		return "trusted"
+	case HopSkipped:
+		return "skipped"
+	case HopDiscarded:
+		return "discarded"
+	case HopBoundary:
+		return "boundary"
+	case HopMalformed:
+		return "malformed"
+	}
+	return fmt.Sprintf("HopVerdict(%d)", uint(v))
+}
+
+// HopDecision is an entry of the explanation trace returned by ForwardedRequest.GetHopTrace.
+type HopDecision struct {
+	Hop     Hop
+	Verdict HopVerdict
+}
+
+func (d HopDecision) String() string {
+	return d.Hop.String() + "=" + d.Verdict.String()
+}
+
+func (f *forwardedRequest) GetHopTrace() []HopDecision {
+	policy := UnknownHopSkip
+	tolerant := false
+	if f.handler != nil {
+		policy = f.handler.UnknownHops
+		tolerant = f.handler.TolerantParsing && !f.handler.StrictParsing &&
+			f.sourcedChain == nil && f.headerMode() != HeaderModeForwarded
+	}
+	res := make([]HopDecision, 0, len(f.rawChain)+1)
+	// positions is the index of the entries in the chain seen by the extractor
+	var positions []int
+	for _, hop := range f.GetRawForwardedChain() {
+		switch {
+		case hop.IP != nil:
+			positions = append(positions, len(res))
+			res = append(res, HopDecision{Hop: hop})
+		case isObfuscatedNode(hop.Obfuscated):
+			switch policy {
+			case UnknownHopTerminate:
+				for i := range res {
+					res[i].Verdict = HopDiscarded
+				}
+				positions = positions[:0]
+				res = append(res, HopDecision{Hop: hop, Verdict: HopDiscarded})
+			case UnknownHopBoundary:
+				positions = append(positions, len(res))
+				res = append(res, HopDecision{Hop: hop, Verdict: HopBoundary})
+			default:
+				res = append(res, HopDecision{Hop: hop, Verdict: HopSkipped})
+			}
+		default:
+			n := len(res)
+			if tolerant {
+				// the same split as parseForwardedFor, each ip found is a hop of its own
+				for _, field := range strings.FieldsFunc(hop.Obfuscated, isLegacySeparator) {
+					if ip := parseIPEntry(field); ip != nil {
+						positions = append(positions, len(res))
+						res = append(res, HopDecision{Hop: Hop{IP: ip}})
+					}
+				}
+			}
+			if len(res) == n {
+				res = append(res, HopDecision{Hop: hop, Verdict: HopMalformed})
+			}
+		}
+	}
+	// the proxies end with the peer, the hops of the chain right before it are the other trusted ones
+	trusted := len(f.trustedProxies) - 1
+	for i := len(positions) - 1; i >= 0; i-- {
+		d := &res[positions[i]]
+		switch {
+		case d.Verdict == HopBoundary:
+		case f.proxyIP == nil:
+			d.Verdict = HopUntrusted
+		case trusted > 0:
+			d.Verdict = HopTrusted
+		case trusted == 0:
+			d.Verdict = HopClient
+		default:
+			d.Verdict = HopUntrusted
+		}
+		trusted--
+	}
+	peer := HopDecision{Hop: Hop{IP: cloneIP(f.trustedRemoteAddr), Port: uint16(f.peerPort)}, Verdict: HopClient}
+	if f.proxyIP != nil {
+		peer.Verdict = HopTrusted
+		peer.Hop.IP = cloneIP(f.proxyIP)
+		if len(f.trustedProxies) > 0 {
+			peer.Hop.IP = cloneIP(f.trustedProxies[len(f.trustedProxies)-1])
+		}
+	}
+	if peer.Hop.IP != nil {
+		res = append(res, peer)
+	}
+	return res
+}
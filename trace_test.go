@@ -0,0 +1,63 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHopTrace(t *testing.T) {
+	cdn, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		policy   UnknownHopPolicy
+		tolerant bool
+		peer     string
+		xff      string
+		want     []string
+	}{
+		{"trusted chain", UnknownHopSkip, false, "10.0.0.2:4711", "192.0.2.66, 203.0.113.7, 10.0.0.5",
+			[]string{"192.0.2.66=untrusted", "203.0.113.7=client", "10.0.0.5=trusted", "10.0.0.2:4711=trusted"}},
+		{"direct", UnknownHopSkip, false, "203.0.113.9:4711", "10.0.0.5",
+			[]string{"10.0.0.5=untrusted", "203.0.113.9:4711=client"}},
+		{"skip", UnknownHopSkip, false, "10.0.0.2:4711", "203.0.113.7, unknown, 10.0.0.5",
+			[]string{"203.0.113.7=client", "unknown=skipped", "10.0.0.5=trusted", "10.0.0.2:4711=trusted"}},
+		{"terminate", UnknownHopTerminate, false, "10.0.0.2:4711", "192.0.2.66, unknown, 203.0.113.7",
+			[]string{"192.0.2.66=discarded", "unknown=discarded", "203.0.113.7=client", "10.0.0.2:4711=trusted"}},
+		{"terminate before trusted hops", UnknownHopTerminate, false, "10.0.0.2:4711", "203.0.113.7, _hidden, 10.0.0.5",
+			[]string{"203.0.113.7=discarded", "_hidden=discarded", "10.0.0.5=client", "10.0.0.2:4711=trusted"}},
+		{"boundary", UnknownHopBoundary, false, "10.0.0.2:4711", "203.0.113.7, unknown, 10.0.0.5",
+			[]string{"203.0.113.7=untrusted", "unknown=boundary", "10.0.0.5=trusted", "10.0.0.2:4711=trusted"}},
+		{"malformed", UnknownHopSkip, false, "10.0.0.2:4711", "203.0.113.7, garbage",
+			[]string{"203.0.113.7=client", "garbage=malformed", "10.0.0.2:4711=trusted"}},
+		{"tolerant split", UnknownHopSkip, true, "10.0.0.2:4711", "192.0.2.66 203.0.113.7",
+			[]string{"192.0.2.66=untrusted", "203.0.113.7=client", "10.0.0.2:4711=trusted"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTTPHandler{Extractor: cdn, UnknownHops: tt.policy, TolerantParsing: tt.tolerant}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.peer
+			r.Header.Set("X-Forwarded-For", tt.xff)
+			var got []string
+			var remote string
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, _ := FromContext(r.Context())
+				remote = f.GetTrustedRemoteAddr().String()
+				for _, d := range f.GetHopTrace() {
+					got = append(got, d.String())
+					if d.Verdict == HopClient && d.Hop.IP.String() != remote {
+						t.Errorf("client %s in the trace, resolved %s", d.Hop.IP, remote)
+					}
+				}
+			}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trace %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package trustedproxy
 
 import (
+	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type ErrorType uint
@@ -14,49 +19,199 @@ const (
 
 	// ErrTypeIPExtractorError is returned when the IP extractor returns an error.
 	ErrTypeIPExtractorError
+
+	// ErrTypeHookError is returned when a hook fails and HookErrorsFatal is set.
+	ErrTypeHookError
+
+	// ErrTypeMalformedHeader is returned when a forwarding header is malformed and StrictParsing is set.
+	ErrTypeMalformedHeader
+
+	// ErrTypeChainSourceError is returned when the ChainSource of the handler returns an error.
+	ErrTypeChainSourceError
 )
 
 // ErrorHandler is the function used to handle errors.
 type ErrorHandler func(t ErrorType, err error, res http.ResponseWriter, req *http.Request)
 
-// DefaultErrorHandler is the default error handler.
-var DefaultErrorHandler ErrorHandler = func(_ ErrorType, err error, res http.ResponseWriter, req *http.Request) {
+// PlainErrorHandler responds to the failed request with the error message and 500 Internal Server Error, it is
+// used by the handlers without an ErrorHandler.
+func PlainErrorHandler(_ ErrorType, err error, res http.ResponseWriter, req *http.Request) {
 	http.Error(res, err.Error(), http.StatusInternalServerError)
 }
 
+// DefaultErrorHandler is the error handler of the handlers without an ErrorHandler.
+//
+// Deprecated: the variable is shared by every handler of the process, replacing it races with the requests
+// in flight and affects the handlers of other libraries. Set HTTPHandler.ErrorHandler or use WithErrorHandler
+// instead. A replaced DefaultErrorHandler is still used, with a warning logged the first time.
+var DefaultErrorHandler ErrorHandler = PlainErrorHandler
+
+// HandlerOption configures the HTTPHandler built by NewHTTPHandler, WithTrustedRequestWith or
+// WithTrustedProxyContextWith.
+type HandlerOption func(h *HTTPHandler)
+
+// WithErrorHandler sets the ErrorHandler of the handler.
+func WithErrorHandler(eh ErrorHandler) HandlerOption {
+	return func(h *HTTPHandler) {
+		h.ErrorHandler = eh
+	}
+}
+
+// NewHTTPHandler returns a handler of the extractor passing the requests to next, configured by the options.
+func NewHTTPHandler(resolver IPExtractor, next http.Handler, opts ...HandlerOption) *HTTPHandler {
+	h := &HTTPHandler{Extractor: resolver, Next: next}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
 // WithTrustedRequest is a middleware that modify the request to use the trusted proxy ip, remote ip, and forwarded ips
 func WithTrustedRequest(resolver IPExtractor, next http.Handler) http.Handler {
-	return &HTTPHandler{
-		Extractor:    resolver,
-		ErrorHandler: DefaultErrorHandler,
-		Next:         next,
-	}
+	return NewHTTPHandler(resolver, next)
+}
+
+// WithTrustedRequestWith is WithTrustedRequest configured by the options.
+func WithTrustedRequestWith(resolver IPExtractor, next http.Handler, opts ...HandlerOption) http.Handler {
+	return NewHTTPHandler(resolver, next, opts...)
 }
 
 // WithTrustedProxyContext is a middleware that set the context with the trusted proxy ip, remote ip, and forwarded ips
-// use context.Value(CtxKeyForwardedRequest).(*forwardedRequest) to get the request with extended info
+// use context.Value(CtxKeyForwardedRequest).(ForwardedRequest) to get the request with extended info,
+// the value is immutable and safe to be shared between goroutines
 func WithTrustedProxyContext(resolver IPExtractor, next http.Handler) http.Handler {
-	handler := &HTTPHandler{
-		Extractor:    resolver,
-		ErrorHandler: DefaultErrorHandler,
-	}
+	return WithTrustedProxyContextWith(resolver, next)
+}
+
+// WithTrustedProxyContextWith is WithTrustedProxyContext configured by the options.
+func WithTrustedProxyContextWith(resolver IPExtractor, next http.Handler, opts ...HandlerOption) http.Handler {
+	handler := NewHTTPHandler(resolver, nil, opts...)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		handler.SetTrustedProxyContext(w, r, next)
 	})
 }
 
+var warnDefaultErrorHandler sync.Once
+
+// fallbackErrorHandler returns the error handler of the handlers without an ErrorHandler, a replaced
+// DefaultErrorHandler is warned about once
+func fallbackErrorHandler() ErrorHandler {
+	eh := DefaultErrorHandler
+	if eh == nil {
+		return PlainErrorHandler
+	}
+	if reflect.ValueOf(eh).Pointer() != reflect.ValueOf(PlainErrorHandler).Pointer() {
+		warnDefaultErrorHandler.Do(func() {
+			log.Printf("trustedproxy: the deprecated DefaultErrorHandler is replaced, set HTTPHandler.ErrorHandler instead")
+		})
+	}
+	return eh
+}
+
 // ExtractForwardedForIPs returns the ip chain from the X-Forwarded-For header
 func ExtractForwardedForIPs(h *http.Header) []net.IP {
-	var res []net.IP
-	headers := h.Values("X-Forwarded-For")
+	res, _ := parseForwardedFor(h.Values("X-Forwarded-For"), false, false)
+	return res
+}
+
+// parseForwardedFor parses the comma separated ip chain, the tolerant mode also accepts the semicolon or space
+// separated entries of legacy appliances, tolerated is true if the tolerance was needed. The undisclosed hops
+// are kept as nil if keepUnknown is set.
+func parseForwardedFor(headers []string, tolerant bool, keepUnknown bool) (res []net.IP, tolerated bool) {
 	for _, header := range headers {
 		for _, val := range strings.Split(header, ",") {
-			ip := net.ParseIP(val)
-			if ip == nil {
+			ip := parseIPEntry(val)
+			if ip != nil {
+				res = append(res, ip)
+				continue
+			}
+			if keepUnknown && isObfuscatedNode(strings.TrimSpace(val)) {
+				res = append(res, nil)
+				continue
+			}
+			if !tolerant {
 				continue
 			}
-			res = append(res, net.ParseIP(val))
+			for _, field := range strings.FieldsFunc(val, isLegacySeparator) {
+				if ip := parseIPEntry(field); ip != nil {
+					res = append(res, ip)
+					tolerated = true
+				}
+			}
 		}
 	}
-	return res
+	return res, tolerated
+}
+
+// validateForwardedFor returns an error if any entry of the chain is not an ip, or an undisclosed hop if
+// allowUnknown is set
+func validateForwardedFor(headers []string, allowUnknown bool) error {
+	for _, header := range headers {
+		for _, val := range strings.Split(header, ",") {
+			if allowUnknown && isObfuscatedNode(strings.TrimSpace(val)) {
+				continue
+			}
+			if parseStrictIPEntry(val, false) == nil {
+				return fmt.Errorf("malformed forwarded for entry %q", strings.TrimSpace(val))
+			}
+		}
+	}
+	return nil
+}
+
+func isLegacySeparator(r rune) bool {
+	return r == ';' || r == ' ' || r == '\t'
+}
+
+// parseIPEntry parses an entry of the ip chain, tolerating a port, brackets and a zone id as emitted by many
+// proxies, e.g. "203.0.113.7:4711", "[2001:db8::1]:8080", "[2001:db8::1]" or "fe80::1%eth0".
+func parseIPEntry(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil
+		}
+		s = s[1:end]
+	} else if strings.Count(s, ":") == 1 {
+		// only an IPv4 address can have a port without brackets
+		s = s[:strings.IndexByte(s, ':')]
+	}
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+// parseStrictIPEntry is parseIPEntry rejecting anything after the ip but a port in 1-65535, the obfuscated
+// ports of the Forwarded header such as "[2001:db8::1]:_8a2f" are accepted too if obfPort is set.
+func parseStrictIPEntry(s string, obfPort bool) net.IP {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	} else if strings.HasPrefix(s, "[") || strings.Count(s, ":") == 1 {
+		host, port, err := net.SplitHostPort(s)
+		if err != nil || !validEntryPort(port, obfPort) {
+			return nil
+		}
+		s = host
+	}
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+func validEntryPort(port string, obfPort bool) bool {
+	if obfPort && strings.HasPrefix(port, "_") {
+		return isObfuscatedNode(port)
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	return err == nil && n > 0
 }
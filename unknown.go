@@ -0,0 +1,99 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// UnknownHopIP is the ip the getters of ForwardedRequest report an undisclosed hop kept by UnknownHopBoundary
+// as, it is never a valid client so a trusted request with this remote address came from an unidentified
+// client. The hops are tracked apart from the addresses of the chain, a client sending a literal 0.0.0.0 is
+// not taken for one, and GetHopTrace tells them apart.
+var UnknownHopIP = net.IPv4zero
+
+// UnknownHopPolicy is how the hops of the chain which do not disclose their ip are handled, i.e. the RFC 7239
+// "unknown" and the obfuscated "_" prefixed identifiers. The treatment of each hop is explained by
+// ForwardedRequest.GetHopTrace.
+type UnknownHopPolicy uint8
+
+const (
+	// UnknownHopSkip drops the undisclosed hops and resolves the chain as if they were not there.
+	UnknownHopSkip UnknownHopPolicy = iota
+
+	// UnknownHopTerminate stops the walk at the rightmost undisclosed hop, the hops at and before it are
+	// discarded as nothing they claim can be attributed.
+	UnknownHopTerminate
+
+	// UnknownHopBoundary keeps the undisclosed hops as an untrusted boundary the walk stops at, even if the
+	// extractor trusts UnknownHopIP, so the remote address of a request coming through one is UnknownHopIP.
+	UnknownHopBoundary
+)
+
+func (p UnknownHopPolicy) String() string {
+	switch p {
+	case UnknownHopSkip:
+		return "skip"
+	case UnknownHopTerminate:
+		return "terminate"
+	case UnknownHopBoundary:
+		return "boundary"
+	}
+	return fmt.Sprintf("UnknownHopPolicy(%d)", uint(p))
+}
+
+// applyUnknownHopPolicy applies the policy to a chain where the undisclosed hops are nil
+func applyUnknownHopPolicy(ips []net.IP, policy UnknownHopPolicy) []net.IP {
+	res := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		switch {
+		case ip != nil:
+			res = append(res, ip)
+		case policy == UnknownHopTerminate:
+			res = res[:0]
+		case policy == UnknownHopBoundary:
+			res = append(res, nil)
+		}
+	}
+	return res
+}
+
+// withUnknownHopIP returns the chain with the undisclosed hops replaced by UnknownHopIP for the extractors and
+// the callers which expect an ip at every position, the chain itself if it has none
+func withUnknownHopIP(ips []net.IP) []net.IP {
+	if lastUnknownHop(ips) < 0 {
+		return ips
+	}
+	res := make([]net.IP, len(ips))
+	for i, ip := range ips {
+		if res[i] = ip; ip == nil {
+			res[i] = UnknownHopIP
+		}
+	}
+	return res
+}
+
+// lastUnknownHop returns the index of the rightmost undisclosed hop of the chain, -1 if there is none
+func lastUnknownHop(ips []net.IP) int {
+	for i := len(ips) - 1; i >= 0; i-- {
+		if ips[i] == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// stopAtUnknownHop ends the walk at the rightmost undisclosed hop whatever the extractor or the strategy made
+// of the UnknownHopIP standing in for it, the resolution is of the chain withUnknownHopIP returned for ips
+func stopAtUnknownHop(res *resolution, ips []net.IP, peer net.IP) {
+	b := lastUnknownHop(ips)
+	if b < 0 || len(res.rest) > len(ips) {
+		return
+	}
+	if res.proxy == nil || len(res.rest) > b {
+		// the remote is right of the boundary, the rest keeps the undisclosed hops out of band
+		res.rest = ips[:len(res.rest)]
+		return
+	}
+	full := append(append([]net.IP{}, ips...), peer)
+	res.proxy, res.remote, res.rest, res.unknownRemote = full[b+1], UnknownHopIP, ips[:b], true
+}
@@ -0,0 +1,56 @@
+package trustedproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestUnknownHopBoundary(t *testing.T) {
+	tests := []struct {
+		name      string
+		cidrs     []string
+		strategy  Strategy
+		xff       string
+		remote    string
+		forwarded []string
+		forward   string
+	}{
+		{"boundary", []string{"10.0.0.0/8"}, StrategyRightmostUntrusted, "203.0.113.7, unknown, 10.0.0.5",
+			"0.0.0.0", []string{"203.0.113.7"}, "203.0.113.7, unknown"},
+		{"literal 0.0.0.0 is not undisclosed", []string{"10.0.0.0/8"}, StrategyRightmostUntrusted, "203.0.113.7, 0.0.0.0, 10.0.0.5",
+			"0.0.0.0", []string{"203.0.113.7"}, "203.0.113.7, 0.0.0.0"},
+		{"trusted unknown hop ip", []string{"0.0.0.0/0"}, StrategyRightmostUntrusted, "203.0.113.7, _hidden, 10.0.0.5",
+			"0.0.0.0", []string{"203.0.113.7"}, "203.0.113.7, unknown"},
+		{"leftmost public", []string{"10.0.0.0/8"}, StrategyLeftmostPublic, "203.0.113.7, unknown, 10.0.0.5",
+			"0.0.0.0", []string{"203.0.113.7"}, "203.0.113.7, unknown"},
+		{"remote right of the boundary", []string{"10.0.0.0/8"}, StrategyRightmostUntrusted, "203.0.113.7, unknown, 198.51.100.2, 10.0.0.5",
+			"198.51.100.2", []string{"203.0.113.7", "0.0.0.0"}, "203.0.113.7, unknown, 198.51.100.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTTPHandler{Extractor: mustWhitelist(t, tt.cidrs...), UnknownHops: UnknownHopBoundary, Strategy: tt.strategy}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = "10.0.0.2:4711"
+			r.Header.Set("X-Forwarded-For", tt.xff)
+			var remote, forward string
+			var forwarded []string
+			h.SetTrustedProxyContext(httptest.NewRecorder(), r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, _ := FromContext(r.Context())
+				remote = f.GetTrustedRemoteAddr().String()
+				forwarded = ipStrings(f.GetTrustedForwardedFor())
+				forward = f.BuildRequestForForward(false).Header.Get("X-Forwarded-For")
+			}))
+			if remote != tt.remote {
+				t.Errorf("remote %s, want %s", remote, tt.remote)
+			}
+			if !reflect.DeepEqual(forwarded, tt.forwarded) {
+				t.Errorf("forwarded for %q, want %q", forwarded, tt.forwarded)
+			}
+			if forward != tt.forward {
+				t.Errorf("forward X-Forwarded-For %q, want %q", forward, tt.forward)
+			}
+		})
+	}
+}
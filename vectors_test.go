@@ -0,0 +1,171 @@
+package trustedproxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type vectorExtractor struct {
+	Type     string
+	Cidrs    []string
+	Offset   uint
+	Depth    uint
+	MaxDepth uint `json:"max_depth"`
+	Entries  []struct {
+		Cidr  string
+		Ports [][2]uint16
+	}
+}
+
+func (v vectorExtractor) build(t *testing.T) IPExtractor {
+	whitelist, err := NewCIDRWhitelist(v.Cidrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch v.Type {
+	case "cidr":
+		return whitelist
+	case "offset":
+		return OffsetIPExtractor(v.Offset)
+	case "verified_offset":
+		return &VerifiedOffsetIPExtractor{Offset: OffsetIPExtractor(v.Offset), Peers: whitelist}
+	case "trust_depth":
+		return TrustDepth(v.Depth)
+	case "tolerant_trust_depth":
+		return TolerantTrustDepth(v.Depth)
+	case "depth_limited":
+		return &DepthLimitedWhitelist{Whitelist: whitelist, MaxDepth: v.MaxDepth}
+	case "single_hop":
+		return &SingleHopWhitelist{Whitelist: whitelist}
+	case "full_chain":
+		return &FullChainWhitelist{Whitelist: whitelist}
+	case "trust_all":
+		return TrustAll{}
+	case "trust_none":
+		return TrustNone{}
+	case "port_aware":
+		p := &PortAwareWhitelist{}
+		for _, e := range v.Entries {
+			n, err := parseCIDR(e.Cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry := TrustEntry{Network: n}
+			for _, r := range e.Ports {
+				entry.Ports = append(entry.Ports, PortRange{Min: r[0], Max: r[1]})
+			}
+			p.Entries = append(p.Entries, entry)
+		}
+		return p
+	}
+	t.Fatalf("unknown extractor type %q", v.Type)
+	return nil
+}
+
+func parseIPs(values []string) []net.IP {
+	var res []net.IP
+	for _, v := range values {
+		res = append(res, net.ParseIP(v))
+	}
+	return res
+}
+
+func TestVectors(t *testing.T) {
+	var vectors struct {
+		Version int
+		Resolve []struct {
+			Name      string
+			Extractor vectorExtractor
+			Peer      string
+			PeerPort  int `json:"peer_port"`
+			Chain     []string
+			Expect    struct {
+				Error  bool
+				Proxy  *string
+				Remote string
+				Rest   []string
+			}
+		}
+		Parse []struct {
+			Name        string
+			Header      string
+			Values      []string
+			Tolerant    bool
+			UnknownHops string `json:"unknown_hops"`
+			Expect      []string
+		}
+	}
+	b, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if vectors.Version != 1 {
+		t.Fatalf("unsupported vectors version %d", vectors.Version)
+	}
+	for _, v := range vectors.Resolve {
+		v := v
+		t.Run("resolve/"+v.Name, func(t *testing.T) {
+			peer := &net.TCPAddr{IP: net.ParseIP(v.Peer), Port: v.PeerPort}
+			proxy, remote, rest, err := resolveRequest(v.Extractor.build(t), nil, peer, parseIPs(v.Chain))
+			if v.Expect.Error {
+				if err == nil {
+					t.Errorf("resolved %v %v %v, want an error", proxy, remote, rest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotProxy, wantProxy := "null", "null"
+			if proxy != nil {
+				gotProxy = proxy.String()
+			}
+			if v.Expect.Proxy != nil {
+				wantProxy = *v.Expect.Proxy
+			}
+			if gotProxy != wantProxy || remote.String() != v.Expect.Remote {
+				t.Errorf("resolved proxy %s remote %v, want %s %s", gotProxy, remote, wantProxy, v.Expect.Remote)
+			}
+			if got, want := ipStrings(rest), append([]string{}, v.Expect.Rest...); !reflect.DeepEqual(got, want) {
+				t.Errorf("rest is %v, want %v", got, want)
+			}
+		})
+	}
+	for _, v := range vectors.Parse {
+		v := v
+		t.Run("parse/"+v.Name, func(t *testing.T) {
+			h := &HTTPHandler{TolerantParsing: v.Tolerant}
+			switch v.UnknownHops {
+			case "", "skip":
+			case "terminate":
+				h.UnknownHops = UnknownHopTerminate
+			case "boundary":
+				h.UnknownHops = UnknownHopBoundary
+			default:
+				t.Fatalf("unknown unknown_hops %q", v.UnknownHops)
+			}
+			if v.Header == "Forwarded" {
+				h.HeaderMode = HeaderModeForwarded
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, value := range v.Values {
+				r.Header.Add(v.Header, value)
+			}
+			ips, err := h.extractIPs(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := ipStrings(ips), append([]string{}, v.Expect...); !reflect.DeepEqual(got, want) {
+				t.Errorf("parsed %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package trustedproxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// XClient is the client metadata of an SMTP XCLIENT or XFORWARD command sent by a mail proxy, the attributes
+// with the "[UNAVAILABLE]" or "[TEMPUNAVAIL]" value are left empty.
+// see https://www.postfix.org/XCLIENT_README.html and https://www.postfix.org/XFORWARD_README.html
+type XClient struct {
+	// Command is either "XCLIENT" or "XFORWARD".
+	Command string
+
+	// Addr and Port are the address of the client, nil and 0 if not sent.
+	Addr net.IP
+	Port int
+
+	// Name is the client host name, Helo the HELO or EHLO name, Proto either "SMTP" or "ESMTP" and
+	// Login the SASL login name.
+	Name  string
+	Helo  string
+	Proto string
+	Login string
+
+	// Attrs is every attribute with the upper-cased name and the decoded value, including the ones above.
+	Attrs map[string]string
+}
+
+// ParseXClient parses an XCLIENT or XFORWARD command line, e.g. "XCLIENT ADDR=192.0.2.1 PORT=4711 HELO=mx",
+// the command name itself is optional.
+func ParseXClient(line string) (XClient, error) {
+	fields := strings.Fields(line)
+	res := XClient{Attrs: map[string]string{}}
+	if len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		res.Command = strings.ToUpper(fields[0])
+		if res.Command != "XCLIENT" && res.Command != "XFORWARD" {
+			return XClient{}, fmt.Errorf("invalid xclient command %q", fields[0])
+		}
+		fields = fields[1:]
+	}
+	for _, field := range fields {
+		name, raw, ok := strings.Cut(field, "=")
+		if !ok || name == "" {
+			return XClient{}, fmt.Errorf("invalid xclient attribute %q", field)
+		}
+		val, err := decodeXText(raw)
+		if err != nil {
+			return XClient{}, fmt.Errorf("invalid xclient attribute %q: %w", field, err)
+		}
+		name = strings.ToUpper(name)
+		res.Attrs[name] = val
+		if val == "[UNAVAILABLE]" || val == "[TEMPUNAVAIL]" {
+			continue
+		}
+		switch name {
+		case "ADDR":
+			// IPv6 addresses are sent with the "IPV6:" prefix
+			if len(val) > 5 && strings.EqualFold(val[:5], "IPV6:") {
+				val = val[5:]
+			}
+			if res.Addr = net.ParseIP(val); res.Addr == nil {
+				return XClient{}, fmt.Errorf("invalid xclient addr %q", val)
+			}
+		case "PORT":
+			port, err := strconv.ParseUint(val, 10, 16)
+			if err != nil {
+				return XClient{}, fmt.Errorf("invalid xclient port %q", val)
+			}
+			res.Port = int(port)
+		case "NAME":
+			res.Name = val
+		case "HELO":
+			res.Helo = val
+		case "PROTO":
+			res.Proto = val
+		case "LOGIN":
+			res.Login = val
+		}
+	}
+	return res, nil
+}
+
+// Chain returns the ip chain forwarded by the mail proxy, the client address if known.
+func (x XClient) Chain() []net.IP {
+	if x.Addr == nil {
+		return nil
+	}
+	return []net.IP{cloneIP(x.Addr)}
+}
+
+// ResolveXClient resolves the client of a mail proxy connection with the same trust policy as the http edge,
+// the metadata is only trusted if the extractor trusts the peer sending it.
+func ResolveXClient(e IPExtractor, peer net.IP, x XClient) (Result, error) {
+	return ResolveChain(e, peer, x.Chain())
+}
+
+// decodeXText decodes the xtext encoding of RFC 3461, "+" followed by two upper-case hex digits
+func decodeXText(s string) (string, error) {
+	if !strings.Contains(s, "+") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '+' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("truncated xtext escape")
+		}
+		v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid xtext escape %q", s[i:i+3])
+		}
+		b.WriteByte(byte(v))
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,86 @@
+package trustedproxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseXClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		command string
+		addr    string
+		port    int
+		helo    string
+		login   string
+		wantErr bool
+	}{
+		{"xclient", "XCLIENT ADDR=192.0.2.1 PORT=4711 HELO=mx.example", "XCLIENT", "192.0.2.1", 4711, "mx.example", "", false},
+		{"xforward lower case", "xforward addr=192.0.2.1 proto=ESMTP", "XFORWARD", "192.0.2.1", 0, "", "", false},
+		{"attributes only", "ADDR=IPV6:2001:db8::1", "", "2001:db8::1", 0, "", "", false},
+		{"unavailable", "XCLIENT ADDR=[UNAVAILABLE] PORT=[TEMPUNAVAIL]", "XCLIENT", "", 0, "", "", false},
+		{"xtext", "XCLIENT LOGIN=user+2Bmail HELO=a+3Db", "XCLIENT", "", 0, "a=b", "user+mail", false},
+		{"other command", "MAIL ADDR=192.0.2.1", "", "", 0, "", "", true},
+		{"attribute without value", "XCLIENT ADDR", "", "", 0, "", "", true},
+		{"empty name", "XCLIENT =192.0.2.1", "", "", 0, "", "", true},
+		{"invalid addr", "XCLIENT ADDR=mx.example", "", "", 0, "", "", true},
+		{"port out of range", "XCLIENT PORT=65536", "", "", 0, "", "", true},
+		{"truncated xtext", "XCLIENT HELO=a+3", "", "", 0, "", "", true},
+		{"invalid xtext", "XCLIENT HELO=a+ZZ", "", "", 0, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, err := ParseXClient(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsed %+v, want an error", x)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := ""
+			if x.Addr != nil {
+				addr = x.Addr.String()
+			}
+			if x.Command != tt.command || addr != tt.addr || x.Port != tt.port || x.Helo != tt.helo || x.Login != tt.login {
+				t.Errorf("parsed %+v", x)
+			}
+		})
+	}
+}
+
+func TestResolveXClient(t *testing.T) {
+	relays, err := NewCIDRWhitelist("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := ParseXClient("XCLIENT ADDR=203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		peer    string
+		x       XClient
+		remote  string
+		trusted bool
+	}{
+		{"trusted relay", "10.0.0.2", x, "203.0.113.7", true},
+		{"untrusted peer", "198.51.100.9", x, "198.51.100.9", false},
+		{"no addr", "10.0.0.2", XClient{}, "10.0.0.2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ResolveXClient(relays, net.ParseIP(tt.peer), tt.x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.RemoteAddr.String() != tt.remote || res.IsTrusted() != tt.trusted {
+				t.Errorf("remote %v trusted %v, want %s trusted %v", res.RemoteAddr, res.IsTrusted(), tt.remote, tt.trusted)
+			}
+		})
+	}
+}